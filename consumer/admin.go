@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-assessment/shared"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Page sizes of the rate limit audit log: the default, and the most one
+// request returns.
+const (
+	defaultRateLimitChanges = 20
+	maxRateLimitChanges     = 500
+)
+
+// actorHeader names who makes a change through the admin API, for the audit
+// log.
+const actorHeader = "X-Actor"
+
+// defaultAdminAddress is where the admin API listens when the config sets no
+// AdminAddress. It is kept off the metrics port, which scrapers must reach.
+const defaultAdminAddress = "0.0.0.0:9093"
+
+// rateLimitAdmin serves the admin API that reads and replaces the rate limits
+// of a running consumer. Every request needs the admin token. Every change is
+// persisted as an entry of the audit log, whose latest entry is applied again
+// at the next start.
+type rateLimitAdmin struct {
+	// mu orders the changes, so that each entry records the limits it
+	// replaced.
+	mu      sync.Mutex
+	limits  *rateLimits
+	store   RateLimitStore
+	metrics *Metrics
+	token   string
+}
+
+func newRateLimitAdmin(limits *rateLimits, store RateLimitStore, metrics *Metrics, token string) *rateLimitAdmin {
+	return &rateLimitAdmin{limits: limits, store: store, metrics: metrics, token: token}
+}
+
+// Register adds the admin handlers to mux, which should serve nothing else.
+func (a *rateLimitAdmin) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/ratelimits", a.authorized(a.serveLimits))
+	mux.HandleFunc("/admin/ratelimits/changes", a.authorized(a.serveChanges))
+}
+
+// restore applies the limits of the latest change, overriding those of the
+// config.
+func (a *rateLimitAdmin) restore(ctx context.Context) error {
+	changes, err := a.store.ListRateLimitChanges(ctx, 1)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	latest := changes[0]
+	if err := a.limits.set(latest.Limits); err != nil {
+		return fmt.Errorf("rate limits of change %d: %v", latest.ID, err)
+	}
+	logrus.Infof("Restored the rate limits set by %s at %s", latest.Actor, latest.ChangedAt.Format(time.RFC3339))
+	return nil
+}
+
+// apply replaces the limits, which must be valid, and records the change.
+func (a *rateLimitAdmin) apply(ctx context.Context, actor string, limits shared.RateLimits) (*RateLimitChange, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	change := &RateLimitChange{
+		ChangedAt: time.Now().UTC(),
+		Actor:     actor,
+		Previous:  a.limits.current(),
+		Limits:    cloneRateLimits(limits),
+	}
+	// Record first, so that no change goes unaudited or is lost on restart.
+	if err := a.store.RecordRateLimitChange(ctx, change); err != nil {
+		return nil, fmt.Errorf("failed to record the change: %v", err)
+	}
+	if err := a.limits.set(limits); err != nil {
+		return nil, err
+	}
+	a.metrics.rateLimitChanges.Inc()
+
+	logrus.WithFields(logrus.Fields{
+		"change":   change.ID,
+		"actor":    actor,
+		"previous": change.Previous,
+		"limits":   change.Limits,
+	}).Info("Rate limits changed")
+	return change, nil
+}
+
+// serveLimits returns the limits in force on GET and replaces them with those
+// in the body on PUT.
+func (a *rateLimitAdmin) serveLimits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, a.limits.current())
+	case http.MethodPut:
+		var limits shared.RateLimits
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&limits); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid rate limits: %v", err))
+			return
+		}
+		if err := validateRateLimits(limits); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		change, err := a.apply(r.Context(), requestActor(r), limits)
+		if err != nil {
+			logrus.Error("Failed to change rate limits: ", err)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, change)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// serveChanges returns the audit log, the latest change first. The limit
+// query parameter sets how many entries to return.
+func (a *rateLimitAdmin) serveChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	limit := defaultRateLimitChanges
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+			return
+		}
+		limit = min(n, maxRateLimitChanges)
+	}
+
+	changes, err := a.store.ListRateLimitChanges(r.Context(), limit)
+	if err != nil {
+		logrus.Error("Failed to list rate limit changes: ", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if changes == nil {
+		changes = []*RateLimitChange{}
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// authorized wraps an admin handler so that it only serves requests with the
+// admin token as bearer token, and answers the others with an error.
+func (a *rateLimitAdmin) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.token == "" {
+			writeError(w, http.StatusForbidden, fmt.Errorf("the admin API is disabled, set AdminToken to enable it"))
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid admin token"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requestActor names who sent a request for the audit log: the X-Actor
+// header, if any, and the remote address.
+func requestActor(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if actor := r.Header.Get(actorHeader); actor != "" {
+		return fmt.Sprintf("%s (%s)", actor, host)
+	}
+	return host
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang-assessment/shared"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testAdminToken = "secret"
+
+// serveAdmin sends a request to the admin API and decodes the JSON response
+// into v, unless v is nil.
+func serveAdmin(t *testing.T, a *rateLimitAdmin, method, target, token, body string, v any) int {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	a.Register(mux)
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set(actorHeader, "alice")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if v != nil {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("Error decoding %s %s response: %v", method, target, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestRateLimitAdmin(t *testing.T) {
+	s, store := newTestServer(t)
+	client := startTestGRPCServer(t, s)
+	a := newRateLimitAdmin(s.limits, store, s.metrics, testAdminToken)
+
+	if code := serveAdmin(t, a, http.MethodGet, "/admin/ratelimits", "", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for GET without a token, got %d", code)
+	}
+	if code := serveAdmin(t, a, http.MethodGet, "/admin/ratelimits/changes", "wrong", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for GET of the changes with a wrong token, got %d", code)
+	}
+
+	var limits shared.RateLimits
+	if code := serveAdmin(t, a, http.MethodGet, "/admin/ratelimits", testAdminToken, "", &limits); code != http.StatusOK {
+		t.Fatalf("Expected GET to succeed, got %d", code)
+	}
+	if !reflect.DeepEqual(limits, shared.RateLimits{}) {
+		t.Errorf("Expected no limits, got %+v", limits)
+	}
+
+	update := `{"PerType": {"Rate": 0.01, "Burst": 1}, "Types": {"2": {"Rate": 0}}}`
+	if code := serveAdmin(t, a, http.MethodPut, "/admin/ratelimits", "", update, nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", code)
+	}
+	if code := serveAdmin(t, a, http.MethodPut, "/admin/ratelimits", "wrong", update, nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with a wrong token, got %d", code)
+	}
+	if code := serveAdmin(t, a, http.MethodPut, "/admin/ratelimits", testAdminToken, `{"Types": {"two": {}}}`, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid task type, got %d", code)
+	}
+	if code := serveAdmin(t, a, http.MethodPut, "/admin/ratelimits", testAdminToken, `{"PerTask": {}}`, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown field, got %d", code)
+	}
+	if n := testutil.ToFloat64(s.metrics.rateLimitChanges); n != 0 {
+		t.Errorf("Expected no changes counted after rejected requests, got %v", n)
+	}
+
+	var change RateLimitChange
+	if code := serveAdmin(t, a, http.MethodPut, "/admin/ratelimits", testAdminToken, update, &change); code != http.StatusOK {
+		t.Fatalf("Expected PUT to succeed, got %d", code)
+	}
+	if change.ID == 0 || change.Actor != "alice (192.0.2.1)" || change.Limits.PerType.Burst != 1 {
+		t.Errorf("Unexpected change: %+v", change)
+	}
+	if n := testutil.ToFloat64(s.metrics.rateLimitChanges); n != 1 {
+		t.Errorf("Expected 1 change counted, got %v", n)
+	}
+
+	// The new limits apply at once.
+	if _, err := sendAs(client, "a", 1); err != nil {
+		t.Fatalf("Expected the first task of type 1 to be admitted, got %v", err)
+	}
+	if _, err := sendAs(client, "a", 1); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected the second task of type 1 to be throttled, got %v", err)
+	}
+	if _, err := sendAs(client, "a", 2); err != nil {
+		t.Errorf("Expected type 2 to be unlimited, got %v", err)
+	}
+
+	var changes []RateLimitChange
+	if code := serveAdmin(t, a, http.MethodGet, "/admin/ratelimits/changes?limit=5", testAdminToken, "", &changes); code != http.StatusOK {
+		t.Fatalf("Expected GET of the changes to succeed, got %d", code)
+	}
+	if len(changes) != 1 || changes[0].ID != change.ID || !reflect.DeepEqual(changes[0].Limits, change.Limits) {
+		t.Errorf("Expected change %+v in the audit log, got %+v", change, changes)
+	}
+	if code := serveAdmin(t, a, http.MethodGet, "/admin/ratelimits/changes?limit=-1", testAdminToken, "", nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a negative limit, got %d", code)
+	}
+	if code := serveAdmin(t, a, http.MethodDelete, "/admin/ratelimits", testAdminToken, "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for DELETE, got %d", code)
+	}
+}
+
+func TestRateLimitAdminDisabledWithoutToken(t *testing.T) {
+	s, store := newTestServer(t)
+	a := newRateLimitAdmin(s.limits, store, s.metrics, "")
+
+	if code := serveAdmin(t, a, http.MethodGet, "/admin/ratelimits", "", "", nil); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for GET without an admin token configured, got %d", code)
+	}
+	if code := serveAdmin(t, a, http.MethodPut, "/admin/ratelimits", "", `{}`, nil); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for PUT without an admin token configured, got %d", code)
+	}
+}
+
+func TestRateLimitAdminRestore(t *testing.T) {
+	s, store := newTestServer(t)
+	a := newRateLimitAdmin(s.limits, store, s.metrics, testAdminToken)
+
+	limits := shared.RateLimits{Clients: map[string]shared.RateLimit{"batch-job": {Rate: 50, Burst: 200}}}
+	if _, err := a.apply(context.Background(), "alice", limits); err != nil {
+		t.Fatalf("Error applying rate limits: %v", err)
+	}
+
+	// A restart starts over from the config, then restores the last change.
+	restarted, err := newRateLimits(shared.RateLimits{PerClient: shared.RateLimit{Rate: 5, Burst: 20}})
+	if err != nil {
+		t.Fatalf("Error building rate limits: %v", err)
+	}
+	if err := newRateLimitAdmin(restarted, store, s.metrics, "").restore(context.Background()); err != nil {
+		t.Fatalf("Error restoring rate limits: %v", err)
+	}
+	if got := restarted.current(); !reflect.DeepEqual(got, limits) {
+		t.Errorf("Expected restored limits %+v, got %+v", limits, got)
+	}
+}
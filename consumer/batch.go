@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"golang-assessment/golang-assessment/proto"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SendTasks accepts a stream of tasks and, once the client closes it, claims
+// room for all of them in the queue and stores them in a single transaction
+// before queueing them for processing. A batch that exceeds the rate or
+// concurrency limits, cannot fit in the queue or holds a task of an unknown
+// type is rejected without storing anything. A stream with more tasks than
+// the queue can ever hold is rejected as soon as it passes that size, rather
+// than buffered to the end.
+func (s *TaskServiceServer) SendTasks(stream grpc.ClientStreamingServer[proto.TaskRequest, proto.SendTasksResponse]) error {
+	ctx := stream.Context()
+
+	if !s.startAdmission() {
+		return errDraining
+	}
+	defer s.admissions.Done()
+
+	var tasks []*Task
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// One unknown type rejects the whole batch before anything is stored.
+		if err := s.checkHandled(req); err != nil {
+			return err
+		}
+		if len(tasks) == cap(s.queue) {
+			return status.Errorf(codes.ResourceExhausted, "batch exceeds the queue capacity of %d tasks", cap(s.queue))
+		}
+		tasks = append(tasks, newTask(req))
+	}
+
+	types := make([]int, len(tasks))
+	for i, task := range tasks {
+		types[i] = task.Type
+	}
+	cancelRateLimits, err := s.checkRateLimits(ctx, types)
+	if err != nil {
+		return err
+	}
+	release, err := s.acquireConcurrency(ctx, types)
+	if err != nil {
+		cancelRateLimits()
+		return err
+	}
+
+	if !s.reserveQueue(len(tasks)) {
+		release(0, true)
+		cancelRateLimits()
+		return status.Errorf(codes.ResourceExhausted, "task queue has room for %d of %d tasks", s.queueRoom(), len(tasks))
+	}
+
+	start := time.Now()
+	err = s.SaveTasks(ctx, tasks)
+	latency := time.Since(start)
+	if err != nil {
+		s.releaseQueue(len(tasks))
+		release(latency, true)
+		cancelRateLimits()
+		logrus.Error("Failed to save tasks: ", err)
+		// The transaction stored none of the tasks, so the client can safely
+		// send the batch again.
+		return status.Errorf(codes.Unavailable, "failed to save tasks: %v", err)
+	}
+
+	resp := &proto.SendTasksResponse{}
+	for _, task := range tasks {
+		resp.Responses = append(resp.Responses, s.admit(ctx, task))
+	}
+	release(latency, false)
+
+	return stream.SendAndClose(resp)
+}
+
+// SaveTasks stores all tasks or none of them and sets their IDs.
+func (s *TaskServiceServer) SaveTasks(ctx context.Context, tasks []*Task) (err error) {
+	ctx, span := tracer().Start(ctx, "SaveTasks", trace.WithAttributes(attribute.Int("tasks.count", len(tasks))))
+	defer func() { endSpan(span, err) }()
+
+	defer observeSince(s.metrics.saveDuration.WithLabelValues("SaveTasks"), time.Now())
+	return s.store.CreateTasks(ctx, tasks)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang-assessment/shared"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Defaults of the concurrency limit settings left at zero.
+const (
+	defaultConcurrencyLatencyTarget = 100 * time.Millisecond
+	defaultConcurrencyBackoff       = 0.9
+)
+
+// adaptiveLimiter bounds the admissions in flight with an AIMD limit: each
+// admission that saved its tasks within the latency target grows the limit
+// by one while at least half of it is in use, and each slower or overloaded
+// one multiplies it by the backoff factor.
+type adaptiveLimiter struct {
+	min, max      float64
+	latencyTarget time.Duration
+	backoff       float64
+
+	limitGauge    prometheus.Gauge
+	inFlightGauge prometheus.Gauge
+
+	// mu guards limit and inFlight.
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+}
+
+// newAdaptiveLimiter builds the limiter from the config, exporting its state
+// in m. It returns nil when the config turns the limit off.
+func newAdaptiveLimiter(config shared.ConcurrencyLimit, m *Metrics) (*adaptiveLimiter, error) {
+	if config.Max <= 0 {
+		return nil, nil
+	}
+
+	minLimit := max(config.Min, 1)
+	if minLimit > config.Max {
+		return nil, fmt.Errorf("concurrency Min %d is above Max %d", config.Min, config.Max)
+	}
+	if config.LatencyTargetMs < 0 {
+		return nil, fmt.Errorf("negative concurrency LatencyTargetMs %d", config.LatencyTargetMs)
+	}
+	if config.Backoff < 0 || config.Backoff >= 1 {
+		return nil, fmt.Errorf("concurrency Backoff %v is not between 0 and 1", config.Backoff)
+	}
+
+	l := &adaptiveLimiter{
+		min:           float64(minLimit),
+		max:           float64(config.Max),
+		latencyTarget: time.Duration(config.LatencyTargetMs) * time.Millisecond,
+		backoff:       config.Backoff,
+		limitGauge:    m.concurrencyLimit,
+		inFlightGauge: m.admissionsInFlight,
+	}
+	if l.latencyTarget == 0 {
+		l.latencyTarget = defaultConcurrencyLatencyTarget
+	}
+	if l.backoff == 0 {
+		l.backoff = defaultConcurrencyBackoff
+	}
+
+	initial := config.Initial
+	if initial == 0 {
+		initial = config.Max
+	}
+	l.limit = min(max(float64(initial), l.min), l.max)
+	l.limitGauge.Set(float64(l.current()))
+	return l, nil
+}
+
+// current is the limit in whole admissions. Callers hold mu, or own l.
+func (l *adaptiveLimiter) current() int {
+	return int(l.limit)
+}
+
+// acquire starts an admission, or reports false if the limit is reached.
+func (l *adaptiveLimiter) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight >= l.current() {
+		return false
+	}
+	l.inFlight++
+	l.inFlightGauge.Set(float64(l.inFlight))
+	return true
+}
+
+// release ends an admission that took latency to save its tasks, and adjusts
+// the limit. overloaded reports that the admission failed for lack of
+// capacity, whatever its latency.
+func (l *adaptiveLimiter) release(latency time.Duration, overloaded bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inFlight := l.inFlight
+	l.inFlight--
+	l.inFlightGauge.Set(float64(l.inFlight))
+
+	switch {
+	case overloaded || latency > l.latencyTarget:
+		l.limit = max(l.limit*l.backoff, l.min)
+	case float64(inFlight)*2 >= l.limit:
+		// Only grow a limit that is in use, or it could grow without bound
+		// while the load is low.
+		l.limit = min(l.limit+1, l.max)
+	}
+	l.limitGauge.Set(float64(l.current()))
+}
+
+// acquireConcurrency starts the admission of tasks of the given types, or
+// rejects them with ResourceExhausted when the concurrency limit is reached.
+// The returned function must be called once the tasks are saved and queued.
+func (s *TaskServiceServer) acquireConcurrency(ctx context.Context, types []int) (func(latency time.Duration, overloaded bool), error) {
+	if s.concurrency == nil {
+		return func(time.Duration, bool) {}, nil
+	}
+	if !s.concurrency.acquire() {
+		s.recordThrottled(limitConcurrency, clientID(ctx), types)
+		return nil, status.Error(codes.ResourceExhausted, "too many tasks being admitted, concurrency limit reached")
+	}
+	return s.concurrency.release, nil
+}
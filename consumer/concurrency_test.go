@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang-assessment/golang-assessment/proto"
+	"golang-assessment/shared"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewAdaptiveLimiter(t *testing.T) {
+	m := newTestMetrics()
+
+	if l, err := newAdaptiveLimiter(shared.ConcurrencyLimit{}, m); l != nil || err != nil {
+		t.Errorf("Expected no limiter without Max, got %v, %v", l, err)
+	}
+
+	l, err := newAdaptiveLimiter(shared.ConcurrencyLimit{Max: 8}, m)
+	if err != nil {
+		t.Fatalf("Error building limiter: %v", err)
+	}
+	if l.current() != 8 || l.min != 1 || l.backoff != defaultConcurrencyBackoff || l.latencyTarget != defaultConcurrencyLatencyTarget {
+		t.Errorf("Unexpected defaults: %+v", l)
+	}
+	if n := testutil.ToFloat64(m.concurrencyLimit); n != 8 {
+		t.Errorf("Expected the limit gauge at 8, got %v", n)
+	}
+
+	invalid := []shared.ConcurrencyLimit{
+		{Min: 5, Max: 4},
+		{Max: 4, Backoff: 1},
+		{Max: 4, Backoff: -0.5},
+		{Max: 4, LatencyTargetMs: -1},
+	}
+	for _, config := range invalid {
+		if _, err := newAdaptiveLimiter(config, m); err == nil {
+			t.Errorf("Expected an error for %+v", config)
+		}
+	}
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	m := newTestMetrics()
+	l, err := newAdaptiveLimiter(shared.ConcurrencyLimit{Initial: 4, Min: 2, Max: 5, LatencyTargetMs: 10, Backoff: 0.5}, m)
+	if err != nil {
+		t.Fatalf("Error building limiter: %v", err)
+	}
+	fast, slow := time.Millisecond, time.Second
+
+	for i := 0; i < 4; i++ {
+		if !l.acquire() {
+			t.Fatalf("Expected admission %d within the limit", i)
+		}
+	}
+	if l.acquire() {
+		t.Fatalf("Expected the fifth admission to be rejected")
+	}
+	if n := testutil.ToFloat64(m.admissionsInFlight); n != 4 {
+		t.Errorf("Expected 4 admissions in flight, got %v", n)
+	}
+
+	// Healthy admissions grow a busy limit, up to Max.
+	l.release(fast, false)
+	l.release(fast, false)
+	if l.current() != 5 {
+		t.Errorf("Expected the limit to grow to Max 5, got %d", l.current())
+	}
+
+	// Slow or overloaded admissions shrink it, down to Min.
+	l.release(slow, false)
+	if l.current() != 2 {
+		t.Errorf("Expected the limit to halve to 2, got %d", l.current())
+	}
+	l.release(fast, true)
+	if l.current() != 2 {
+		t.Errorf("Expected the limit to stay at Min 2, got %d", l.current())
+	}
+	if n := testutil.ToFloat64(m.concurrencyLimit); n != 2 {
+		t.Errorf("Expected the limit gauge at 2, got %v", n)
+	}
+
+	// An idle limit does not grow.
+	l.limit = 4
+	l.acquire()
+	l.release(fast, false)
+	if l.current() != 4 {
+		t.Errorf("Expected an idle limit to stay at 4, got %d", l.current())
+	}
+}
+
+func TestSendTaskConcurrencyLimit(t *testing.T) {
+	s, _ := newTestServer(t)
+	var err error
+	s.concurrency, err = newAdaptiveLimiter(shared.ConcurrencyLimit{Initial: 1, Max: 1}, s.metrics)
+	if err != nil {
+		t.Fatalf("Error building limiter: %v", err)
+	}
+	client := startTestGRPCServer(t, s)
+	ctx := context.Background()
+
+	// Hold the only slot, as a slow admission would.
+	if !s.concurrency.acquire() {
+		t.Fatalf("Expected to acquire the only slot")
+	}
+	_, err = client.SendTask(ctx, &proto.TaskRequest{Type: 1, Value: 1})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Expected ResourceExhausted at the concurrency limit, got %v", err)
+	}
+	if n := testutil.ToFloat64(s.metrics.tasksThrottled.WithLabelValues(limitConcurrency, otherClient, "1")); n != 1 {
+		t.Errorf("Expected 1 task throttled by the concurrency limit, got %v", n)
+	}
+
+	s.concurrency.release(time.Millisecond, false)
+	if _, err := client.SendTask(ctx, &proto.TaskRequest{Type: 1, Value: 1}); err != nil {
+		t.Errorf("Expected the task to be admitted once the slot is free, got %v", err)
+	}
+	if n := testutil.ToFloat64(s.metrics.admissionsInFlight); n != 0 {
+		t.Errorf("Expected no admissions in flight, got %v", n)
+	}
+}
@@ -2,13 +2,26 @@ package main
 
 import (
 	"context"
-	"database/sql"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"golang-assessment/golang-assessment/proto"
+	"golang-assessment/shared"
 
-	_ "github.com/glebarez/sqlite"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	pb "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type mockTaskServiceClient struct {
@@ -40,46 +53,639 @@ func TestSendTask(t *testing.T) {
 }
 
 func TestSaveTask(t *testing.T) {
-	db, err := sql.Open("sqlite", ":memory:")
+	s, store := newTestServer(t)
+
+	task := &Task{
+		Type:      2,
+		Value:     50,
+		State:     "received",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	err := s.SaveTask(context.Background(), task)
 	if err != nil {
-		t.Fatalf("Failed to open test database: %v", err)
+		t.Errorf("Error saving task: %v", err)
 	}
-	defer db.Close()
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS tasks (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		type INTEGER NOT NULL,
-		value INTEGER NOT NULL,
-		state TEXT NOT NULL,
-		created_at DATETIME NOT NULL,
-		updated_at DATETIME NOT NULL
-	)`)
+	if task.ID == 0 {
+		t.Fatalf("Expected task ID to be set after saving")
+	}
+
+	saved, err := store.GetTask(context.Background(), task.ID)
 	if err != nil {
-		t.Fatalf("Failed to create tasks table: %v", err)
+		t.Fatalf("Error getting task: %v", err)
+	}
+
+	if saved.Type != 2 || saved.Value != 50 {
+		t.Errorf("Unexpected task: %+v", saved)
 	}
+}
+
+// newTestServer returns a server backed by an in-memory task store, with its
+// metrics in a registry of its own.
+func newTestServer(t *testing.T) (*TaskServiceServer, *memoryTaskStore) {
+	t.Helper()
+
+	store := newMemoryTaskStore()
+	return NewTaskServiceServer(store, 10, newTestMetrics()), store
+}
+
+func newTestMetrics() *Metrics {
+	return NewMetrics(prometheus.NewRegistry(), shared.HistogramBuckets{})
+}
+
+// newTestStorage returns a migrated SQLite storage in memory.
+func newTestStorage(t *testing.T) *storage {
+	t.Helper()
+
+	st, err := openStorage("sqlite://:memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+	// Every connection to :memory: gets its own database.
+	st.db.SetMaxOpenConns(1)
+
+	if err := runMigrations(st); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	return st
+}
 
-	s := NewTaskServiceServer(db)
+func TestProcessTask(t *testing.T) {
+	s, store := newTestServer(t)
 
 	task := &Task{
-		Type:      2,
-		Value:     50,
-		State:     "received",
+		Type:      3,
+		Value:     1,
+		State:     stateReceived,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
+	if err := s.SaveTask(context.Background(), task); err != nil {
+		t.Fatalf("Error saving task: %v", err)
+	}
 
-	err = s.SaveTask(task)
+	s.processTask(context.Background(), task)
+
+	saved, err := store.GetTask(context.Background(), task.ID)
 	if err != nil {
-		t.Errorf("Error saving task: %v", err)
+		t.Fatalf("Error getting task: %v", err)
+	}
+
+	if saved.State != stateDone {
+		t.Errorf("Expected state '%s', got '%s'", stateDone, saved.State)
+	}
+	if saved.Result != "slept 1ms" {
+		t.Errorf("Expected result 'slept 1ms', got '%s'", saved.Result)
+	}
+}
+
+// flakyUpdateStore fails the first update of a task to the failState of the
+// store it wraps.
+type flakyUpdateStore struct {
+	TaskStore
+	failState string
+	once      sync.Once
+}
+
+func (s *flakyUpdateStore) UpdateTaskState(ctx context.Context, id int, state string, updatedAt time.Time) error {
+	failed := false
+	if state == s.failState {
+		s.once.Do(func() { failed = true })
+	}
+	if failed {
+		return errors.New("database is locked")
+	}
+	return s.TaskStore.UpdateTaskState(ctx, id, state, updatedAt)
+}
+
+func TestProcessTaskRequeuesTaskItCannotStart(t *testing.T) {
+	s, store := newTestServer(t)
+	s.store = &flakyUpdateStore{TaskStore: store, failState: stateProcessing}
+	ids := admitTasks(t, s, 1, 1)
+
+	s.StartWorkers(context.Background(), 1)
+	defer s.Shutdown(context.Background())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		task, err := store.GetTask(context.Background(), ids[0])
+		if err != nil {
+			t.Fatalf("Error getting task: %v", err)
+		}
+		if task.State == stateDone {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the task to be processed after being queued again, still %s", task.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGetTask(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	resp, err := s.SendTask(context.Background(), &proto.TaskRequest{Type: 4, Value: 20})
+	if err != nil {
+		t.Fatalf("Error in SendTask: %v", err)
+	}
+
+	task, err := s.GetTask(context.Background(), &proto.GetTaskRequest{Id: resp.Id})
+	if err != nil {
+		t.Fatalf("Error in GetTask: %v", err)
 	}
 
-	var taskCount int
-	err = db.QueryRow("SELECT COUNT(*) FROM tasks WHERE type = ? AND value = ?", 2, 50).Scan(&taskCount)
+	if task.Type != 4 || task.Value != 20 || task.State != stateReceived {
+		t.Errorf("Unexpected task: %+v", task)
+	}
+
+	_, err = s.GetTask(context.Background(), &proto.GetTaskRequest{Id: resp.Id + 1})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound for a missing task, got %v", err)
+	}
+}
+
+func TestListTasks(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	for i := 0; i < 5; i++ {
+		task := &Task{
+			Type:      i % 2,
+			Value:     i,
+			State:     stateDone,
+			CreatedAt: time.Now().UTC(),
+			UpdatedAt: time.Now().UTC(),
+		}
+		if err := s.SaveTask(context.Background(), task); err != nil {
+			t.Fatalf("Error saving task: %v", err)
+		}
+	}
+
+	var ids []int32
+	req := &proto.ListTasksRequest{PageSize: 2, Type: pb.Int32(0), State: stateDone}
+	for {
+		resp, err := s.ListTasks(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Error in ListTasks: %v", err)
+		}
+		for _, task := range resp.Tasks {
+			if task.Type != 0 {
+				t.Errorf("Expected only type 0 tasks, got %+v", task)
+			}
+			ids = append(ids, task.Id)
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+
+	if len(ids) != 3 {
+		t.Errorf("Expected 3 tasks of type 0, got %v", ids)
+	}
+
+	resp, err := s.ListTasks(context.Background(), &proto.ListTasksRequest{
+		CreatedAfter: timestamppb.New(time.Now().Add(time.Hour)),
+	})
+	if err != nil {
+		t.Fatalf("Error in ListTasks: %v", err)
+	}
+	if len(resp.Tasks) != 0 {
+		t.Errorf("Expected no tasks created in the future, got %d", len(resp.Tasks))
+	}
+}
+
+// startTestGRPCServer serves s over an in-memory listener and returns a
+// client connected to it.
+func startTestGRPCServer(t *testing.T, s *TaskServiceServer, opts ...grpc.ServerOption) proto.TaskServiceClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer(append(opts, grpc.StatsHandler(otelgrpc.NewServerHandler()))...)
+	proto.RegisterTaskServiceServer(grpcServer, s)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial test server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return proto.NewTaskServiceClient(conn)
+}
+
+func TestWatchTask(t *testing.T) {
+	s, _ := newTestServer(t)
+	client := startTestGRPCServer(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := client.SendTask(ctx, &proto.TaskRequest{Type: 1, Value: 10})
+	if err != nil {
+		t.Fatalf("Error in SendTask: %v", err)
+	}
+
+	stream, err := client.WatchTask(ctx, &proto.WatchTaskRequest{Id: resp.Id})
 	if err != nil {
-		t.Errorf("Error querying task: %v", err)
+		t.Fatalf("Error in WatchTask: %v", err)
+	}
+
+	// Start processing only once the snapshot has been taken.
+	first, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Error receiving snapshot: %v", err)
+	}
+	if first.Task.State != stateReceived {
+		t.Errorf("Expected snapshot in state '%s', got '%s'", stateReceived, first.Task.State)
+	}
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	s.StartWorkers(workerCtx, 1)
+
+	var states []string
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error receiving event: %v", err)
+		}
+		states = append(states, event.PreviousState+"->"+event.Task.State)
+	}
+
+	want := []string{"received->processing", "processing->done"}
+	if strings.Join(states, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected transitions %v, got %v", want, states)
+	}
+}
+
+// snapshotHookStore runs beforeGet before every GetTask of the store it wraps.
+type snapshotHookStore struct {
+	TaskStore
+	beforeGet func()
+}
+
+func (s *snapshotHookStore) GetTask(ctx context.Context, id int) (*Task, error) {
+	s.beforeGet()
+	return s.TaskStore.GetTask(ctx, id)
+}
+
+func TestWatchTaskSkipsTransitionsInSnapshot(t *testing.T) {
+	s, store := newTestServer(t)
+	client := startTestGRPCServer(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	task := newTask(&proto.TaskRequest{Type: 1, Value: 10})
+	if err := s.SaveTask(ctx, task); err != nil {
+		t.Fatalf("Error saving task: %v", err)
+	}
+
+	// Between the subscription and the snapshot, the task fails an attempt
+	// and comes back to received.
+	var once sync.Once
+	s.store = &snapshotHookStore{TaskStore: store, beforeGet: func() {
+		once.Do(func() {
+			for _, state := range []string{stateProcessing, stateRetrying, stateReceived} {
+				if err := s.updateTaskState(ctx, task, state); err != nil {
+					t.Errorf("Error updating task state: %v", err)
+				}
+			}
+		})
+	}}
+
+	stream, err := client.WatchTask(ctx, &proto.WatchTaskRequest{Id: int32(task.ID)})
+	if err != nil {
+		t.Fatalf("Error in WatchTask: %v", err)
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Error receiving snapshot: %v", err)
+	}
+	if first.Task.State != stateReceived || first.Task.Version != 3 {
+		t.Fatalf("Expected snapshot in state '%s' at version 3, got '%s' at %d", stateReceived, first.Task.State, first.Task.Version)
+	}
+
+	if err := s.updateTaskState(ctx, task, stateProcessing); err != nil {
+		t.Fatalf("Error updating task state: %v", err)
+	}
+	if err := s.completeTask(ctx, task, "ok"); err != nil {
+		t.Fatalf("Error completing task: %v", err)
 	}
 
-	if taskCount != 1 {
-		t.Errorf("Expected 1 task, found %d", taskCount)
+	var states []string
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error receiving event: %v", err)
+		}
+		states = append(states, event.PreviousState+"->"+event.Task.State)
+	}
+	if want := "received->processing,processing->done"; strings.Join(states, ",") != want {
+		t.Errorf("Expected transitions [%s], got %v", want, states)
+	}
+}
+
+func TestWatchTasksFilters(t *testing.T) {
+	s, _ := newTestServer(t)
+	client := startTestGRPCServer(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.WatchTasks(ctx, &proto.WatchTasksRequest{State: stateReceived, Type: pb.Int32(7)})
+	if err != nil {
+		t.Fatalf("Error in WatchTasks: %v", err)
+	}
+	// Make sure the watcher is subscribed before publishing.
+	for {
+		s.events.mu.Lock()
+		n := len(s.events.subs)
+		s.events.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	s.publishTransition(&Task{ID: 1, Type: 6, State: stateReceived}, "")
+	s.publishTransition(&Task{ID: 2, Type: 7, State: stateProcessing}, stateReceived)
+	s.publishTransition(&Task{ID: 3, Type: 7, State: stateReceived}, "")
+
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Error receiving event: %v", err)
+	}
+	if event.Task.Id != 3 {
+		t.Errorf("Expected event for task 3, got task %d", event.Task.Id)
+	}
+}
+
+func TestSendTaskRejectsTaskWhenQueueIsFull(t *testing.T) {
+	s, store := newTestServer(t)
+	s.queue = make(chan *Task, 1)
+
+	client := startTestGRPCServer(t, s)
+	ctx := context.Background()
+
+	if _, err := client.SendTask(ctx, &proto.TaskRequest{Type: 1, Value: 5}); err != nil {
+		t.Fatalf("Error in SendTask: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := client.SendTask(ctx, &proto.TaskRequest{Type: 1, Value: 5}); status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("Expected ResourceExhausted, got %v", err)
+		}
+	}
+
+	counts, err := store.CountTasksByState(ctx)
+	if err != nil {
+		t.Fatalf("Error counting tasks: %v", err)
+	}
+	if len(counts) != 1 || counts[stateReceived] != 1 {
+		t.Errorf("Expected only the queued task stored, found %v", counts)
+	}
+}
+
+// failingSaveStore fails every attempt to store tasks in the store it wraps.
+type failingSaveStore struct {
+	TaskStore
+}
+
+func (failingSaveStore) CreateTask(context.Context, *Task) error {
+	return errors.New("database is locked")
+}
+
+func (failingSaveStore) CreateTasks(context.Context, []*Task) error {
+	return errors.New("database is locked")
+}
+
+func TestSendTaskReportsSaveErrorsAsUnavailable(t *testing.T) {
+	s, store := newTestServer(t)
+	s.store = failingSaveStore{TaskStore: store}
+	client := startTestGRPCServer(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := client.SendTask(ctx, &proto.TaskRequest{Type: 1, Value: 5}); status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected Unavailable from SendTask, got %v", err)
+	}
+
+	stream, err := client.SendTasks(ctx)
+	if err != nil {
+		t.Fatalf("Error in SendTasks: %v", err)
+	}
+	if err := stream.Send(&proto.TaskRequest{Type: 1, Value: 5}); err != nil {
+		t.Fatalf("Error sending task: %v", err)
+	}
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected Unavailable from SendTasks, got %v", err)
+	}
+
+	if room := s.queueRoom(); room != cap(s.queue) {
+		t.Errorf("Expected the queue room claimed for unsaved tasks back, got %d of %d", room, cap(s.queue))
+	}
+}
+
+func TestSendTasks(t *testing.T) {
+	s, store := newTestServer(t)
+	client := startTestGRPCServer(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.SendTasks(ctx)
+	if err != nil {
+		t.Fatalf("Error in SendTasks: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := stream.Send(&proto.TaskRequest{Type: int32(i), Value: 5}); err != nil {
+			t.Fatalf("Error sending task: %v", err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("Error closing stream: %v", err)
+	}
+
+	if len(resp.Responses) != 3 {
+		t.Fatalf("Expected 3 responses, got %d", len(resp.Responses))
+	}
+	for i, taskResp := range resp.Responses {
+		task, err := store.GetTask(context.Background(), int(taskResp.Id))
+		if err != nil {
+			t.Fatalf("Error getting task %d: %v", taskResp.Id, err)
+		}
+		if task.Type != i {
+			t.Errorf("Expected task %d to have type %d, got %d", taskResp.Id, i, task.Type)
+		}
+	}
+}
+
+func TestSendTasksRejectsBatchLargerThanQueue(t *testing.T) {
+	s, store := newTestServer(t)
+	s.queue = make(chan *Task, 1)
+
+	client := startTestGRPCServer(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.SendTasks(ctx)
+	if err != nil {
+		t.Fatalf("Error in SendTasks: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := stream.Send(&proto.TaskRequest{Type: 1, Value: 5}); err != nil {
+			t.Fatalf("Error sending task: %v", err)
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted, got %v", err)
+	}
+
+	counts, err := store.CountTasksByState(context.Background())
+	if err != nil {
+		t.Fatalf("Error counting tasks: %v", err)
+	}
+	if len(counts) != 0 {
+		t.Errorf("Expected rejected batch not to be stored, found %v", counts)
+	}
+}
+
+func TestSendTasksRejectsStreamBeyondQueueCapacityBeforeItCloses(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.queue = make(chan *Task, 2)
+
+	client := startTestGRPCServer(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.SendTasks(ctx)
+	if err != nil {
+		t.Fatalf("Error in SendTasks: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := stream.Send(&proto.TaskRequest{Type: 1, Value: 5}); err != nil {
+			t.Fatalf("Error sending task: %v", err)
+		}
+	}
+
+	// The stream is still open, so only an early rejection answers.
+	if err := stream.RecvMsg(&proto.SendTasksResponse{}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted before the stream is closed, got %v", err)
+	}
+}
+
+func TestSendTasksCountsClaimedQueueRoom(t *testing.T) {
+	s, store := newTestServer(t)
+	s.queue = make(chan *Task, 3)
+
+	client := startTestGRPCServer(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sendBatch := func(n int) (*proto.SendTasksResponse, error) {
+		stream, err := client.SendTasks(ctx)
+		if err != nil {
+			t.Fatalf("Error in SendTasks: %v", err)
+		}
+		for i := 0; i < n; i++ {
+			if err := stream.Send(&proto.TaskRequest{Type: 1, Value: 5}); err != nil {
+				t.Fatalf("Error sending task: %v", err)
+			}
+		}
+		return stream.CloseAndRecv()
+	}
+
+	// Room claimed for a task about to be queued is not free, although the
+	// queue is still empty.
+	if !s.reserveQueue(2) {
+		t.Fatal("Expected room in the queue")
+	}
+	if _, err := sendBatch(2); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted, got %v", err)
+	}
+	s.releaseQueue(2)
+
+	resp, err := sendBatch(3)
+	if err != nil {
+		t.Fatalf("Error sending a batch that fits: %v", err)
+	}
+	if len(resp.Responses) != 3 || len(s.queue) != 3 {
+		t.Errorf("Expected 3 tasks queued, got %d responses and %d queued", len(resp.Responses), len(s.queue))
+	}
+
+	counts, err := store.CountTasksByState(context.Background())
+	if err != nil {
+		t.Fatalf("Error counting tasks: %v", err)
+	}
+	if len(counts) != 1 || counts[stateReceived] != 3 {
+		t.Errorf("Expected only the 3 queued tasks stored, found %v", counts)
+	}
+}
+
+func TestMigrateCommand(t *testing.T) {
+	st := newTestStorage(t)
+
+	if err := runMigrateCommand(st, []string{"down"}); err != nil {
+		t.Fatalf("Error migrating down: %v", err)
+	}
+	if _, err := st.db.Exec("SELECT version FROM tasks"); err == nil {
+		t.Errorf("Expected version column to be dropped after migrating down")
+	}
+
+	if err := runMigrateCommand(st, []string{"up"}); err != nil {
+		t.Fatalf("Error migrating up: %v", err)
+	}
+	if _, err := st.db.Exec("SELECT version FROM tasks"); err != nil {
+		t.Errorf("Expected version column after migrating up: %v", err)
+	}
+
+	if err := runMigrateCommand(st, []string{"sideways"}); err == nil {
+		t.Errorf("Expected an error for an unknown migrate command")
+	}
+}
+
+func TestRunMigrationsRefusesDirtyDatabase(t *testing.T) {
+	st := newTestStorage(t)
+
+	var version string
+	if err := st.db.QueryRow("SELECT version FROM schema_migrations").Scan(&version); err != nil {
+		t.Fatalf("Error reading schema version: %v", err)
+	}
+	if _, err := st.db.Exec("UPDATE schema_migrations SET dirty = 1"); err != nil {
+		t.Fatalf("Error marking database dirty: %v", err)
+	}
+
+	if err := runMigrations(st); err == nil || !strings.Contains(err.Error(), "dirty") {
+		t.Errorf("Expected a dirty database error, got %v", err)
+	}
+
+	if err := runMigrateCommand(st, []string{"force", version}); err != nil {
+		t.Fatalf("Error forcing version: %v", err)
+	}
+	if err := runMigrations(st); err != nil {
+		t.Errorf("Expected migrations to succeed after forcing the version: %v", err)
 	}
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Handler does the work for tasks of one type. The returned result is stored
+// on the task once it is done, and an error fails the attempt.
+type Handler interface {
+	Handle(ctx context.Context, task *Task) (string, error)
+}
+
+// HandlerFunc adapts a function to the Handler interface.
+type HandlerFunc func(ctx context.Context, task *Task) (string, error)
+
+func (f HandlerFunc) Handle(ctx context.Context, task *Task) (string, error) {
+	return f(ctx, task)
+}
+
+// HandlerRegistry maps task types to their handlers. Tasks of a type without
+// a handler are not accepted.
+type HandlerRegistry struct {
+	mu       sync.RWMutex
+	handlers map[int]Handler
+}
+
+func NewHandlerRegistry() *HandlerRegistry {
+	return &HandlerRegistry{handlers: make(map[int]Handler)}
+}
+
+// Register makes h handle tasks of taskType, replacing any previous handler.
+func (r *HandlerRegistry) Register(taskType int, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handlers[taskType] = h
+}
+
+// Lookup returns the handler for taskType, if there is one.
+func (r *HandlerRegistry) Lookup(taskType int) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	h, ok := r.handlers[taskType]
+	return h, ok
+}
+
+// defaultHandlers returns a registry handling the types 0 to 9 the producer
+// generates with sleepHandler.
+func defaultHandlers() *HandlerRegistry {
+	r := NewHandlerRegistry()
+	for taskType := 0; taskType <= 9; taskType++ {
+		r.Register(taskType, HandlerFunc(sleepHandler))
+	}
+	return r
+}
+
+// sleepHandler simulates work by sleeping for the task value in milliseconds.
+func sleepHandler(ctx context.Context, task *Task) (string, error) {
+	select {
+	case <-time.After(time.Duration(task.Value) * time.Millisecond):
+		return fmt.Sprintf("slept %dms", task.Value), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang-assessment/golang-assessment/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHandlerResultIsStored(t *testing.T) {
+	s, store := newTestServer(t)
+	s.handlers.Register(42, HandlerFunc(func(_ context.Context, task *Task) (string, error) {
+		return fmt.Sprintf("%d squared is %d", task.Value, task.Value*task.Value), nil
+	}))
+
+	ctx := context.Background()
+	task := newTask(&proto.TaskRequest{Type: 42, Value: 7})
+	if err := s.SaveTask(ctx, task); err != nil {
+		t.Fatalf("Error saving task: %v", err)
+	}
+
+	s.processTask(ctx, task)
+
+	client := startTestGRPCServer(t, s)
+	got, err := client.GetTask(ctx, &proto.GetTaskRequest{Id: int32(task.ID)})
+	if err != nil {
+		t.Fatalf("Error in GetTask: %v", err)
+	}
+	if got.State != stateDone || got.Result != "7 squared is 49" {
+		t.Errorf("Unexpected task: %v", got)
+	}
+
+	saved, err := store.GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatalf("Error getting task: %v", err)
+	}
+	if saved.Result != got.Result {
+		t.Errorf("Expected stored result '%s', got '%s'", got.Result, saved.Result)
+	}
+}
+
+func TestUnknownTaskTypeIsRejected(t *testing.T) {
+	s, store := newTestServer(t)
+	client := startTestGRPCServer(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.SendTask(ctx, &proto.TaskRequest{Type: 10, Value: 1})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument from SendTask, got %v", err)
+	}
+
+	stream, err := client.SendTasks(ctx)
+	if err != nil {
+		t.Fatalf("Error in SendTasks: %v", err)
+	}
+	for _, taskType := range []int32{1, 10} {
+		if err := stream.Send(&proto.TaskRequest{Type: taskType, Value: 1}); err != nil {
+			break
+		}
+	}
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument from SendTasks, got %v", err)
+	}
+
+	counts, err := store.CountTasksByState(ctx)
+	if err != nil {
+		t.Fatalf("Error counting tasks: %v", err)
+	}
+	if len(counts) != 0 {
+		t.Errorf("Expected no tasks to be stored, got %v", counts)
+	}
+}
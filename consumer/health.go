@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang-assessment/golang-assessment/proto"
+	"golang-assessment/shared"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthCheckInterval is how often the gRPC health status is brought in line
+// with readiness.
+const healthCheckInterval = 5 * time.Second
+
+// newHealth returns the readiness checks of the consumer: the database
+// answers, and its schema is still at the version migrated to at startup.
+func newHealth(st *storage, version uint) *shared.Health {
+	h := shared.NewHealth()
+	h.AddCheck("database", st.db.PingContext)
+	h.AddCheck("migrations", func(ctx context.Context) error {
+		current, dirty, err := st.schemaVersion(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read schema version: %v", err)
+		}
+		if dirty {
+			return fmt.Errorf("schema is dirty at version %d", current)
+		}
+		if current != version {
+			return fmt.Errorf("schema is at version %d, expected %d", current, version)
+		}
+		return nil
+	})
+	return h
+}
+
+// schemaVersion reads the version golang-migrate recorded in the database.
+func (st *storage) schemaVersion(ctx context.Context) (uint, bool, error) {
+	var version int64
+	var dirty bool
+	err := st.db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if err != nil {
+		return 0, false, err
+	}
+	return uint(version), dirty, nil
+}
+
+// healthReporter publishes the readiness of the consumer through the
+// grpc.health.v1 service, both for the server as a whole and for the task
+// service.
+type healthReporter struct {
+	health *shared.Health
+	server *health.Server
+}
+
+func newHealthReporter(h *shared.Health) *healthReporter {
+	return &healthReporter{health: h, server: health.NewServer()}
+}
+
+// update sets the serving status from the readiness checks.
+func (r *healthReporter) update(ctx context.Context) {
+	status := healthpb.HealthCheckResponse_SERVING
+	if !r.health.Ready(ctx) {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	r.server.SetServingStatus("", status)
+	r.server.SetServingStatus(proto.TaskService_ServiceDesc.ServiceName, status)
+}
+
+// run updates the serving status every interval until ctx is cancelled.
+func (r *healthReporter) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, interval)
+		r.update(checkCtx)
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain marks the consumer as shutting down. /readyz fails and every
+// service reports NOT_SERVING from now on, so that clients move away before
+// the server stops.
+func (r *healthReporter) drain() {
+	r.health.SetDraining()
+	r.server.Shutdown()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"golang-assessment/golang-assessment/proto"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthChecksDatabaseAndSchema(t *testing.T) {
+	st := newTestStorage(t)
+	ctx := context.Background()
+
+	version, dirty, err := st.schemaVersion(ctx)
+	if err != nil || dirty || version == 0 {
+		t.Fatalf("Unexpected schema version %d, dirty %t: %v", version, dirty, err)
+	}
+
+	h := newHealth(st, version)
+	if failed := h.Check(ctx); failed != nil {
+		t.Fatalf("Expected a migrated database to be ready, got %v", failed)
+	}
+
+	if _, err := st.db.Exec("UPDATE schema_migrations SET version = version - 1"); err != nil {
+		t.Fatalf("Error changing schema version: %v", err)
+	}
+	if failed := h.Check(ctx); failed["migrations"] == nil {
+		t.Errorf("Expected the migrations check to fail after a rollback, got %v", failed)
+	}
+
+	st.db.Close()
+	if failed := h.Check(ctx); failed["database"] == nil {
+		t.Errorf("Expected the database check to fail once closed, got %v", failed)
+	}
+}
+
+func TestHealthReporter(t *testing.T) {
+	st := newTestStorage(t)
+	ctx := context.Background()
+
+	version, _, err := st.schemaVersion(ctx)
+	if err != nil {
+		t.Fatalf("Error reading schema version: %v", err)
+	}
+	reporter := newHealthReporter(newHealth(st, version))
+
+	expectServingStatus := func(want healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		for _, service := range []string{"", proto.TaskService_ServiceDesc.ServiceName} {
+			resp, err := reporter.server.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+			if err != nil {
+				t.Fatalf("Error checking %q: %v", service, err)
+			}
+			if resp.Status != want {
+				t.Errorf("Expected %q to be %v, got %v", service, want, resp.Status)
+			}
+		}
+	}
+
+	reporter.update(ctx)
+	expectServingStatus(healthpb.HealthCheckResponse_SERVING)
+
+	reporter.drain()
+	expectServingStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	if reporter.health.Ready(ctx) {
+		t.Errorf("Expected a draining consumer not to be ready")
+	}
+
+	// Updates after draining must not bring the service back.
+	reporter.update(ctx)
+	expectServingStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+}
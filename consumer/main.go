@@ -2,30 +2,45 @@ package main
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"golang-assessment/golang-assessment/proto"
+	"golang-assessment/shared"
 
 	_ "net/http/pprof"
 
-	_ "github.com/glebarez/sqlite"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/time/rate"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
 var version = "1.0.0"
 
+const (
+	stateReceived   = "received"
+	stateProcessing = "processing"
+	stateDone       = "done"
+	stateFailed     = "failed"
+	stateRetrying   = "retrying"
+	stateDead       = "dead"
+)
+
 type Task struct {
 	ID        int
 	Type      int
@@ -33,96 +48,168 @@ type Task struct {
 	State     string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	Comment   string
+	// Attempts counts the failed attempts at handling the task.
+	Attempts  int
+	NextRunAt time.Time
+	LastError string
+	// Result is what the handler returned once the task is done.
+	Result string
+	// Version counts the state changes of the task. The store increments it
+	// with every update.
+	Version int
+	// spanContext is the span the task was admitted in, for its processing
+	// to continue the trace. It is not stored.
+	spanContext trace.SpanContext
 }
 
 type TaskServiceServer struct {
-	db *sql.DB
+	store   TaskStore
+	metrics *Metrics
+	queue   chan *Task
+	events  *taskBroker
+	wg      sync.WaitGroup
+	// queued counts the queue slots in use: tasks in the queue, and room
+	// claimed with reserveQueue for tasks about to be put in it. It never
+	// exceeds the capacity of the queue, so a task put in claimed room never
+	// waits. dequeued is signalled whenever a worker frees a slot.
+	queueMu  sync.Mutex
+	queued   int
+	dequeued chan struct{}
+	// handlers do the work of each task type. A returned error is retried
+	// according to the retry policies.
+	handlers *HandlerRegistry
+	retry    *retryPolicies
+	limits   *rateLimits
+	// concurrency bounds the admissions in flight, unless nil.
+	concurrency *adaptiveLimiter
+
+	// mu guards draining. Once draining, no new tasks are admitted, and
+	// stopping is closed when the admissions in flight are done.
+	mu          sync.Mutex
+	draining    bool
+	admissions  sync.WaitGroup
+	stopping    chan struct{}
+	stopWorkers context.CancelFunc
 	proto.UnimplementedTaskServiceServer
 }
 
-var tasksProcessed = prometheus.NewCounterVec(
-	prometheus.CounterOpts{
-		Name: "tasks_processed_total",
-		Help: "Total number of tasks processed",
-	},
-	[]string{"type"},
-)
-
-var taskState = prometheus.NewCounterVec(
-	prometheus.CounterOpts{
-		Name: "tasks_state_count",
-		Help: "Number of tasks in each state",
-	},
-	[]string{"state"},
-)
-
-var limiter = rate.NewLimiter(1, 5)
-
-func init() {
-	prometheus.MustRegister(tasksProcessed)
-	prometheus.MustRegister(taskState)
+// NewTaskServiceServer returns a server whose accepted tasks are buffered in a
+// queue of queueSize until a worker started with StartWorkers picks them up.
+func NewTaskServiceServer(store TaskStore, queueSize int, metrics *Metrics) *TaskServiceServer {
+	return &TaskServiceServer{
+		store:    store,
+		metrics:  metrics,
+		queue:    make(chan *Task, queueSize),
+		events:   newTaskBroker(),
+		dequeued: make(chan struct{}, 1),
+		handlers: defaultHandlers(),
+		retry:    &retryPolicies{fallback: defaultRetryPolicy},
+		limits:   &rateLimits{clients: newKeyedLimiter(shared.RateLimit{}, nil), types: newKeyedLimiter(shared.RateLimit{}, nil)},
+		stopping: make(chan struct{}),
+	}
 }
 
-func NewTaskServiceServer(db *sql.DB) *TaskServiceServer {
-	return &TaskServiceServer{db: db}
-}
+// SaveTask stores the task and sets its ID.
+func (s *TaskServiceServer) SaveTask(ctx context.Context, task *Task) (err error) {
+	ctx, span := tracer().Start(ctx, "SaveTask")
+	defer func() {
+		span.SetAttributes(attribute.Int("task.id", task.ID))
+		endSpan(span, err)
+	}()
 
-func (s *TaskServiceServer) SaveTask(task *Task) error {
-	_, err := s.db.Exec("INSERT INTO tasks (type, value, state, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
-		task.Type, task.Value, task.State, task.CreatedAt, task.UpdatedAt)
-	return err
+	defer observeSince(s.metrics.saveDuration.WithLabelValues("SaveTask"), time.Now())
+	return s.store.CreateTask(ctx, task)
 }
 
 func (s *TaskServiceServer) SendTask(ctx context.Context, req *proto.TaskRequest) (*proto.TaskResponse, error) {
 
-	if err := limiter.Wait(ctx); err != nil {
+	if err := s.checkHandled(req); err != nil {
 		return nil, err
 	}
 
-	task := Task{
-		Type:      int(req.Type),
-		Value:     int(req.Value),
-		State:     "received", // Initial state
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	if !s.startAdmission() {
+		return nil, errDraining
 	}
+	defer s.admissions.Done()
 
-	taskState.With(prometheus.Labels{"state": task.State}).Inc()
+	types := []int{int(req.Type)}
+	cancelRateLimits, err := s.checkRateLimits(ctx, types)
+	if err != nil {
+		return nil, err
+	}
+	release, err := s.acquireConcurrency(ctx, types)
+	if err != nil {
+		cancelRateLimits()
+		return nil, err
+	}
 
-	time.Sleep(time.Duration(task.Value) * time.Millisecond)
-	task.State = "done"
+	// Claim room in the queue first, so that a full queue rejects the task
+	// without storing it.
+	if !s.reserveQueue(1) {
+		release(0, true)
+		cancelRateLimits()
+		return nil, status.Errorf(codes.ResourceExhausted, "task queue is full")
+	}
 
-	taskState.With(prometheus.Labels{"state": task.State}).Inc()
+	task := newTask(req)
 
-	err := s.SaveTask(&task)
+	start := time.Now()
+	err = s.SaveTask(ctx, task)
+	latency := time.Since(start)
 	if err != nil {
+		s.releaseQueue(1)
+		release(latency, true)
+		cancelRateLimits()
 		logrus.Error("Failed to save task: ", err)
-		return nil, fmt.Errorf("failed to save task: %v", err)
+		// Nothing was stored, so the client can safely send the task again.
+		return nil, status.Errorf(codes.Unavailable, "failed to save task: %v", err)
 	}
 
-	tasksProcessed.With(prometheus.Labels{"type": strconv.Itoa(task.Type)}).Inc()
-
-	logrus.Infof("Task saved: %+v", task)
+	resp := s.admit(ctx, task)
+	release(latency, false)
+	return resp, nil
+}
 
-	return &proto.TaskResponse{
-		Status: "Task saved successfully",
-	}, nil
+// checkHandled returns InvalidArgument unless a handler is registered for the
+// type of the requested task.
+func (s *TaskServiceServer) checkHandled(req *proto.TaskRequest) error {
+	if _, ok := s.handlers.Lookup(int(req.Type)); !ok {
+		return status.Errorf(codes.InvalidArgument, "unknown task type %d", req.Type)
+	}
+	return nil
 }
 
-func runMigrations(db *sql.DB) {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS tasks (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		type INTEGER NOT NULL,
-		value INTEGER NOT NULL,
-		state TEXT NOT NULL,
-		created_at DATETIME NOT NULL,
-		updated_at DATETIME NOT NULL
-	)`)
-	if err != nil {
-		log.Fatalf("Failed to create tasks table: %v", err)
+// newTask builds a task in its initial state from a request.
+func newTask(req *proto.TaskRequest) *Task {
+	now := time.Now().UTC()
+	return &Task{
+		Type:      int(req.Type),
+		Value:     int(req.Value),
+		State:     stateReceived, // Initial state
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
+}
+
+// admit announces a freshly saved task and hands it to the workers, in room
+// claimed for it with reserveQueue.
+func (s *TaskServiceServer) admit(ctx context.Context, task *Task) *proto.TaskResponse {
+	s.metrics.trackTaskState("", task.State)
+	s.publishTransition(task, "")
+
+	logrus.Infof("Task saved: %+v", *task)
+
+	task.spanContext = trace.SpanContextFromContext(ctx)
 
-	log.Println("Tasks table created or already exists.")
+	// The task belongs to the workers once enqueued, so don't touch it after.
+	id := task.ID
+	s.enqueue(task)
+
+	return &proto.TaskResponse{
+		Status: "Task saved successfully",
+		Id:     int32(id),
+	}
 }
 
 func main() {
@@ -131,6 +218,35 @@ func main() {
 		return
 	}
 
+	config, err := shared.LoadConfig()
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+
+	shutdownTracing, err := shared.InitTracing(context.Background(), "consumer", config.Tracing)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	databaseURL := config.DatabaseURL
+	if databaseURL == "" {
+		databaseURL = defaultDatabaseURL
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		st, err := openStorage(databaseURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer st.Close()
+
+		if err := runMigrateCommand(st, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 	logrus.SetLevel(logrus.InfoLevel)
 
@@ -138,47 +254,139 @@ func main() {
 		log.Println(http.ListenAndServe("localhost:6062", nil))
 	}()
 
-	dbPath := "/app/tasks.db"
-	db, err := sql.Open("sqlite", dbPath)
+	st, err := openStorage(databaseURL)
 	if err != nil {
-		log.Fatalf("Failed to connect to the database: %v", err)
+		log.Fatal(err)
 	}
-	defer db.Close()
+	defer st.Close()
 
-	_, err = os.Stat(dbPath)
-	if os.IsNotExist(err) {
-		file, err := os.Create(dbPath)
-		if err != nil {
-			log.Fatalf("Failed to create the database file: %v", err)
-		}
-		file.Close()
-		log.Printf("Database file created: %s\n", dbPath)
-	} else if err != nil {
-		log.Fatalf("Error checking the database file: %v", err)
+	if err := runMigrations(st); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+	schemaVersion, _, err := st.schemaVersion(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to read schema version: %v", err)
 	}
+	health := newHealth(st, schemaVersion)
+	reporter := newHealthReporter(health)
 
-	runMigrations(db)
+	registry := shared.NewRegistry()
+	metrics := NewMetrics(registry, config.Buckets)
+
+	store := newSQLTaskStore(st)
+	if err := metrics.seedTaskStates(context.Background(), store); err != nil {
+		log.Fatalf("Failed to count tasks by state: %v", err)
+	}
 
+	http.Handle("/metrics", metricsHandler(registry))
+	health.Register(http.DefaultServeMux)
+	metricsServer := &http.Server{Addr: "0.0.0.0:9092"}
 	go func() {
-		http.Handle("/metrics", promhttp.Handler())
-		log.Fatal(http.ListenAndServe("0.0.0.0:9092", nil))
+		if err := metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
 	}()
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	listener, err := net.Listen("tcp", ":50051")
 	if err != nil {
 		log.Fatal("Failed to listen on port 50051: ", err)
 	}
 
-	grpcServer := grpc.NewServer()
-	taskServiceServer := NewTaskServiceServer(db)
+	rpcMetrics := shared.NewServerRPCMetrics()
+	registry.MustRegister(rpcMetrics)
+
+	serverOptions := append(rpcMetrics.ServerOptions(), grpc.StatsHandler(otelgrpc.NewServerHandler(
+		otelgrpc.WithFilter(filters.Not(filters.HealthCheck())),
+	)))
+	grpcServer := grpc.NewServer(serverOptions...)
+	taskServiceServer := NewTaskServiceServer(store, config.QueueSize, metrics)
+	taskServiceServer.retry, err = newRetryPolicies(config.Retry, config.RetryByType)
+	if err != nil {
+		log.Fatalf("Invalid retry configuration: %v", err)
+	}
+	taskServiceServer.limits, err = newRateLimits(config.RateLimits)
+	if err != nil {
+		log.Fatalf("Invalid rate limit configuration: %v", err)
+	}
+	taskServiceServer.concurrency, err = newAdaptiveLimiter(config.Concurrency, metrics)
+	if err != nil {
+		log.Fatalf("Invalid concurrency configuration: %v", err)
+	}
+	admin := newRateLimitAdmin(taskServiceServer.limits, store, metrics, config.AdminToken)
+	if err := admin.restore(context.Background()); err != nil {
+		log.Fatalf("Failed to restore rate limits: %v", err)
+	}
+	var adminServer *http.Server
+	if config.AdminToken != "" {
+		adminAddress := config.AdminAddress
+		if adminAddress == "" {
+			adminAddress = defaultAdminAddress
+		}
+		adminMux := http.NewServeMux()
+		admin.Register(adminMux)
+		adminServer = &http.Server{Addr: adminAddress, Handler: adminMux}
+		go func() {
+			if err := adminServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	} else {
+		logrus.Warn("AdminToken is not set, the admin API is disabled")
+	}
+	// Read the unfinished tasks before the retry scheduler starts, or a retry
+	// it moves back to received meanwhile would be queued twice.
+	if err := taskServiceServer.resumeTasks(ctx); err != nil {
+		log.Fatalf("Failed to resume unfinished tasks: %v", err)
+	}
+	// Workers outlive ctx: Shutdown stops them once the queue is drained.
+	taskServiceServer.StartWorkers(context.Background(), config.Workers)
 
 	proto.RegisterTaskServiceServer(grpcServer, taskServiceServer)
+	healthpb.RegisterHealthServer(grpcServer, reporter.server)
+	go reporter.run(ctx, healthCheckInterval)
 
 	logrus.Info("gRPC server is running on port 50051")
 
-	if err := grpcServer.Serve(listener); err != nil {
-		log.Fatalf("Failed to serve gRPC server: %v", err)
-	}
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			log.Fatalf("Failed to serve gRPC server: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	logrus.Infof("Shutting down, draining tasks for up to %s", config.ShutdownTimeout())
 
-	select {}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout())
+	defer cancel()
+
+	// Fail health checks first so that clients stop sending, then refuse new
+	// RPCs while the running ones finish and the workers drain the queue.
+	reporter.drain()
+	grpcStopped := make(chan struct{})
+	go func() {
+		stopGRPCServer(shutdownCtx, grpcServer)
+		close(grpcStopped)
+	}()
+	if err := taskServiceServer.Shutdown(shutdownCtx); err != nil {
+		logrus.Warnf("Stopped before draining every task: %v", err)
+	}
+	// Watch streams only end when the tasks they follow do, or now.
+	taskServiceServer.events.close()
+	<-grpcStopped
+
+	// Serve /metrics until the tasks are settled, so the final counts can
+	// still be scraped.
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		logrus.Warnf("Failed to stop the metrics server: %v", err)
+	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			logrus.Warnf("Failed to stop the admin server: %v", err)
+		}
+	}
+	logrus.Info("Consumer stopped")
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang-assessment/shared"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// taskStates lists every state a task can be in.
+var taskStates = []string{stateReceived, stateProcessing, stateDone, stateFailed, stateRetrying, stateDead}
+
+// Built-in histogram buckets, in seconds, used unless the config sets others.
+var (
+	defaultEndToEndBuckets = []float64{.01, .05, .1, .5, 1, 2.5, 5, 10, 30, 60, 300}
+	defaultSaveTaskBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+)
+
+// Metrics holds the task metrics of a consumer.
+type Metrics struct {
+	tasksProcessed      *prometheus.CounterVec
+	tasksProcessedValue *prometheus.CounterVec
+	tasksInState        *prometheus.GaugeVec
+	tasksThrottled      *prometheus.CounterVec
+	rateLimitChanges    prometheus.Counter
+	concurrencyLimit    prometheus.Gauge
+	admissionsInFlight  prometheus.Gauge
+
+	handlerDuration *prometheus.HistogramVec
+	endToEnd        *prometheus.HistogramVec
+	saveDuration    *prometheus.HistogramVec
+}
+
+// NewMetrics creates the task metrics, with histogram buckets from the config,
+// and registers them in reg.
+func NewMetrics(reg prometheus.Registerer, buckets shared.HistogramBuckets) *Metrics {
+	m := &Metrics{
+		tasksProcessed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "tasks_processed_total",
+				Help: "Total number of tasks processed",
+			},
+			[]string{"type"},
+		),
+		tasksProcessedValue: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "tasks_processed_value_total",
+				Help: "Sum of the values of the tasks processed, by type",
+			},
+			[]string{"type"},
+		),
+		tasksInState: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "tasks_in_state",
+				Help: "Number of tasks currently in each state",
+			},
+			[]string{"state"},
+		),
+		tasksThrottled: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "tasks_throttled_total",
+				Help: "Number of tasks rejected by the rate and concurrency limits, by the limit hit, client and task type",
+			},
+			[]string{"limit", "client", "type"},
+		),
+		rateLimitChanges: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rate_limit_changes_total",
+			Help: "Number of times the rate limits were changed through the admin API",
+		}),
+		concurrencyLimit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "task_admission_concurrency_limit",
+			Help: "Current adaptive limit on the SendTask and SendTasks calls admitting tasks at once",
+		}),
+		admissionsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "task_admissions_in_flight",
+			Help: "Number of SendTask and SendTasks calls admitting tasks under the concurrency limit",
+		}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "task_handler_duration_seconds",
+			Help:    "Time spent in the task handler, by task type",
+			Buckets: bucketsOr(buckets.Handler, prometheus.DefBuckets),
+		}, []string{"type"}),
+		endToEnd: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "task_end_to_end_seconds",
+			Help:    "Time from the creation of a task until it is done, by task type",
+			Buckets: bucketsOr(buckets.EndToEnd, defaultEndToEndBuckets),
+		}, []string{"type"}),
+		saveDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "task_save_duration_seconds",
+			Help:    "Time spent writing new tasks to the database, by method",
+			Buckets: bucketsOr(buckets.SaveTask, defaultSaveTaskBuckets),
+		}, []string{"method"}),
+	}
+
+	reg.MustRegister(
+		m.tasksProcessed,
+		m.tasksProcessedValue,
+		m.tasksInState,
+		m.tasksThrottled,
+		m.rateLimitChanges,
+		m.concurrencyLimit,
+		m.admissionsInFlight,
+		m.handlerDuration,
+		m.endToEnd,
+		m.saveDuration,
+	)
+	return m
+}
+
+func bucketsOr(buckets, def []float64) []float64 {
+	if len(buckets) == 0 {
+		return def
+	}
+	return buckets
+}
+
+// metricsHandler serves the metrics in reg. OpenMetrics is negotiated with
+// scrapers that accept it, since exemplars are only exposed in that format.
+func metricsHandler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
+// trackTaskState moves a task from previous to current in the tasks_in_state
+// gauge. previous is empty for a new task.
+func (m *Metrics) trackTaskState(previous, current string) {
+	if previous != "" {
+		m.tasksInState.WithLabelValues(previous).Dec()
+	}
+	m.tasksInState.WithLabelValues(current).Inc()
+}
+
+// seedTaskStates sets the tasks_in_state gauge to the number of tasks in
+// each state in the store, so that it matches the table after a restart.
+func (m *Metrics) seedTaskStates(ctx context.Context, store TaskStore) error {
+	counts, err := store.CountTasksByState(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.tasksInState.Reset()
+	for _, state := range taskStates {
+		m.tasksInState.WithLabelValues(state).Set(float64(counts[state]))
+	}
+	for state, n := range counts {
+		// Keep states written by other versions of the consumer visible.
+		m.tasksInState.WithLabelValues(state).Set(float64(n))
+	}
+	return nil
+}
+
+// observeSince records the seconds elapsed since start.
+func observeSince(o prometheus.Observer, start time.Time) {
+	o.Observe(time.Since(start).Seconds())
+}
+
+// taskExemplar labels an exemplar with the ID of the task, so a sample can be
+// traced back to the task it came from.
+func taskExemplar(task *Task) prometheus.Labels {
+	return prometheus.Labels{"task_id": strconv.Itoa(task.ID)}
+}
+
+// observeTask records v for the task, with the task ID as exemplar.
+func observeTask(o prometheus.Observer, v float64, task *Task) {
+	o.(prometheus.ExemplarObserver).ObserveWithExemplar(v, taskExemplar(task))
+}
+
+func typeLabel(task *Task) string {
+	return strconv.Itoa(task.Type)
+}
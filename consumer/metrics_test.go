@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang-assessment/golang-assessment/proto"
+	"golang-assessment/monitoring"
+	"golang-assessment/shared"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTaskStateGauge(t *testing.T) {
+	s, store := newTestServer(t)
+	ctx := context.Background()
+
+	var tasks []*Task
+	for i := 0; i < 3; i++ {
+		task := newTask(&proto.TaskRequest{Type: 1, Value: 1})
+		if err := s.SaveTask(ctx, task); err != nil {
+			t.Fatalf("Error saving task: %v", err)
+		}
+		tasks = append(tasks, task)
+	}
+	if err := store.UpdateTaskState(ctx, tasks[0].ID, stateDone, tasks[0].UpdatedAt); err != nil {
+		t.Fatalf("Error updating task state: %v", err)
+	}
+
+	// A stale value must not survive seeding.
+	s.metrics.tasksInState.WithLabelValues(stateProcessing).Set(7)
+
+	if err := s.metrics.seedTaskStates(ctx, store); err != nil {
+		t.Fatalf("Error seeding task state gauge: %v", err)
+	}
+	expectTaskStates(t, s, map[string]int{stateReceived: 2, stateDone: 1})
+
+	tasks[1].State = stateReceived
+	s.processTask(ctx, tasks[1])
+	expectTaskStates(t, s, map[string]int{stateReceived: 1, stateDone: 2})
+
+	task := newTask(&proto.TaskRequest{Type: 1, Value: 1})
+	if err := s.SaveTask(ctx, task); err != nil {
+		t.Fatalf("Error saving task: %v", err)
+	}
+	if !s.reserveQueue(1) {
+		t.Fatal("Expected room in the queue")
+	}
+	s.admit(ctx, task)
+	expectTaskStates(t, s, map[string]int{stateReceived: 2, stateDone: 2})
+}
+
+// expectTaskStates compares the tasks_in_state gauge with counts, expecting
+// zero for every state not in counts.
+func expectTaskStates(t *testing.T, s *TaskServiceServer, counts map[string]int) {
+	t.Helper()
+
+	var expected strings.Builder
+	expected.WriteString("# HELP tasks_in_state Number of tasks currently in each state\n")
+	expected.WriteString("# TYPE tasks_in_state gauge\n")
+	for _, state := range taskStates {
+		expected.WriteString("tasks_in_state{state=\"" + state + "\"} " + strconv.Itoa(counts[state]) + "\n")
+	}
+	if err := testutil.CollectAndCompare(s.metrics.tasksInState, strings.NewReader(expected.String())); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLatencyHistograms(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx := context.Background()
+
+	client := startTestGRPCServer(t, s)
+	resp, err := client.SendTask(ctx, &proto.TaskRequest{Type: 4, Value: 1})
+	if err != nil {
+		t.Fatalf("Error in SendTask: %v", err)
+	}
+	task, err := s.store.GetTask(ctx, int(resp.Id))
+	if err != nil {
+		t.Fatalf("Error getting task: %v", err)
+	}
+	<-s.queue
+	s.processTask(ctx, task)
+
+	tests := []struct {
+		name     string
+		observer prometheus.Observer
+	}{
+		{"SaveTask", s.metrics.saveDuration.WithLabelValues("SaveTask")},
+		{"handler", s.metrics.handlerDuration.WithLabelValues("4")},
+		{"end-to-end", s.metrics.endToEnd.WithLabelValues("4")},
+	}
+	for _, tt := range tests {
+		if n := writeMetric(t, tt.observer.(prometheus.Metric)).Histogram.GetSampleCount(); n != 1 {
+			t.Errorf("Expected 1 %s observation, got %d", tt.name, n)
+		}
+	}
+}
+
+func TestNewMetricsUsesConfiguredBuckets(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewMetrics(reg, shared.HistogramBuckets{Handler: []float64{0.5, 1}})
+
+	m.handlerDuration.WithLabelValues("1").Observe(0.75)
+
+	expected := `
+# HELP task_handler_duration_seconds Time spent in the task handler, by task type
+# TYPE task_handler_duration_seconds histogram
+task_handler_duration_seconds_bucket{type="1",le="0.5"} 0
+task_handler_duration_seconds_bucket{type="1",le="1"} 1
+task_handler_duration_seconds_bucket{type="1",le="+Inf"} 1
+task_handler_duration_seconds_sum{type="1"} 0.75
+task_handler_duration_seconds_count{type="1"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "task_handler_duration_seconds"); err != nil {
+		t.Error(err)
+	}
+
+	save := m.saveDuration.WithLabelValues("SaveTask")
+	save.Observe(0)
+	if n := len(writeMetric(t, save.(prometheus.Metric)).Histogram.Bucket); n != len(defaultSaveTaskBuckets) {
+		t.Errorf("Expected %d default save buckets, got %d", len(defaultSaveTaskBuckets), n)
+	}
+}
+
+func TestProcessedValueSum(t *testing.T) {
+	s, _ := newTestServer(t)
+	ctx := context.Background()
+
+	for _, value := range []int32{3, 4} {
+		task := newTask(&proto.TaskRequest{Type: 5, Value: value})
+		if err := s.SaveTask(ctx, task); err != nil {
+			t.Fatalf("Error saving task: %v", err)
+		}
+		s.processTask(ctx, task)
+	}
+
+	expected := `
+# HELP tasks_processed_value_total Sum of the values of the tasks processed, by type
+# TYPE tasks_processed_value_total counter
+tasks_processed_value_total{type="5"} 7
+`
+	if err := testutil.CollectAndCompare(s.metrics.tasksProcessedValue, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.ToFloat64(s.metrics.tasksProcessed.WithLabelValues("5")); n != 2 {
+		t.Errorf("Expected 2 processed tasks of type 5, got %v", n)
+	}
+}
+
+func writeMetric(t *testing.T, m prometheus.Metric) *dto.Metric {
+	t.Helper()
+
+	metric := &dto.Metric{}
+	if err := m.Write(metric); err != nil {
+		t.Fatalf("Error writing metric: %v", err)
+	}
+	return metric
+}
+
+func TestTaskExemplars(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	s := NewTaskServiceServer(newMemoryTaskStore(), 10, NewMetrics(reg, shared.HistogramBuckets{}))
+	ctx := context.Background()
+
+	task := newTask(&proto.TaskRequest{Type: 6, Value: 1})
+	if err := s.SaveTask(ctx, task); err != nil {
+		t.Fatalf("Error saving task: %v", err)
+	}
+	s.processTask(ctx, task)
+	taskID := strconv.Itoa(task.ID)
+
+	exemplars := map[string]*dto.Exemplar{
+		"tasks_processed_total":         writeMetric(t, s.metrics.tasksProcessed.WithLabelValues("6")).Counter.Exemplar,
+		"task_handler_duration_seconds": histogramExemplar(writeMetric(t, s.metrics.handlerDuration.WithLabelValues("6").(prometheus.Metric))),
+		"task_end_to_end_seconds":       histogramExemplar(writeMetric(t, s.metrics.endToEnd.WithLabelValues("6").(prometheus.Metric))),
+	}
+	for name, exemplar := range exemplars {
+		if exemplar == nil || len(exemplar.Label) != 1 || exemplar.Label[0].GetName() != "task_id" || exemplar.Label[0].GetValue() != taskID {
+			t.Errorf("Expected %s exemplar with task_id %s, got %v", name, taskID, exemplar)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	rec := httptest.NewRecorder()
+	metricsHandler(reg).ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Errorf("Expected an OpenMetrics response, got %q", ct)
+	}
+	want := `tasks_processed_total{type="6"} 1.0 # {task_id="` + taskID + `"} 1.0`
+	if body := rec.Body.String(); !strings.Contains(body, want) {
+		t.Errorf("Expected %q in the OpenMetrics output:\n%s", want, body)
+	}
+}
+
+// histogramExemplar returns the exemplar of the first bucket that has one.
+func histogramExemplar(metric *dto.Metric) *dto.Exemplar {
+	for _, bucket := range metric.Histogram.Bucket {
+		if bucket.Exemplar != nil {
+			return bucket.Exemplar
+		}
+	}
+	return nil
+}
+
+// TestMonitoringQueriesUseExportedMetrics checks the consumer series used by
+// the shipped Prometheus rules and Grafana dashboards against the metrics a
+// consumer exports once it has handled a task.
+func TestMonitoringQueriesUseExportedMetrics(t *testing.T) {
+	queries, err := monitoring.Load("../monitoring")
+	if err != nil {
+		t.Fatalf("Error loading monitoring queries: %v", err)
+	}
+
+	reg := shared.NewRegistry()
+	s := NewTaskServiceServer(newMemoryTaskStore(), 10, NewMetrics(reg, shared.HistogramBuckets{}))
+	rpcMetrics := shared.NewServerRPCMetrics()
+	reg.MustRegister(rpcMetrics)
+	ctx := context.Background()
+
+	s.limits, err = newRateLimits(shared.RateLimits{PerClient: shared.RateLimit{Rate: 0.001, Burst: 1}})
+	if err != nil {
+		t.Fatalf("Error building rate limits: %v", err)
+	}
+
+	client := startTestGRPCServer(t, s, rpcMetrics.ServerOptions()...)
+	resp, err := client.SendTask(ctx, &proto.TaskRequest{Type: 7, Value: 1})
+	if err != nil {
+		t.Fatalf("Error in SendTask: %v", err)
+	}
+	if _, err := client.SendTask(ctx, &proto.TaskRequest{Type: 7, Value: 1}); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Expected the second task to be throttled, got %v", err)
+	}
+	task, err := s.store.GetTask(ctx, int(resp.Id))
+	if err != nil {
+		t.Fatalf("Error getting task: %v", err)
+	}
+	<-s.queue
+	s.processTask(ctx, task)
+
+	exported, err := monitoring.ExportedNames(reg)
+	if err != nil {
+		t.Fatalf("Error gathering metrics: %v", err)
+	}
+	for _, s := range queries.MissingForJob("consumer", exported) {
+		t.Errorf("%s: the consumer does not export %s", s.Source, s.Metric)
+	}
+}
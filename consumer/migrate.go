@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
+)
+
+// migrationsURL points at the numbered up/down migrations for each engine in
+// sql/, relative to the consumer's working directory. sqlc reads the same
+// files.
+const migrationsURL = "file://../sql/migrations/"
+
+const migrateUsage = "usage: consumer migrate up [N] | down [N] | version | force VERSION"
+
+// newMigrate returns a migrator for the storage. Closing it would close the
+// database as well, so callers just drop it when done.
+func newMigrate(st *storage) (*migrate.Migrate, error) {
+	var driver database.Driver
+	var err error
+	switch st.engine {
+	case enginePostgres:
+		driver, err = pgx.WithInstance(st.db, &pgx.Config{})
+	default:
+		driver, err = sqlite.WithInstance(st.db, &sqlite.Config{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migration driver: %v", err)
+	}
+
+	m, err := migrate.NewWithDatabaseInstance(migrationsURL+st.engine, st.engine, driver)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %v", err)
+	}
+	return m, nil
+}
+
+// runMigrations applies every pending migration. A database left dirty by a
+// failed migration is refused until an operator repairs it and runs
+// `consumer migrate force`.
+func runMigrations(st *storage) error {
+	m, err := newMigrate(st)
+	if err != nil {
+		return err
+	}
+
+	version, dirty, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+	if dirty {
+		return fmt.Errorf("database is dirty at version %d, fix it and run `consumer migrate force VERSION`", version)
+	}
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("failed to apply migrations: %v", err)
+	}
+
+	version, _, err = m.Version()
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	log.Printf("Database schema is at version %d", version)
+	return nil
+}
+
+// runMigrateCommand implements the `consumer migrate` subcommand. `up`
+// applies all pending migrations unless a step count is given, `down` reverts
+// one migration unless a step count is given.
+func runMigrateCommand(st *storage, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	m, err := newMigrate(st)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		steps, err := parseSteps(args[1:])
+		if err != nil {
+			return err
+		}
+		if steps == 0 {
+			err = m.Up()
+		} else {
+			err = m.Steps(steps)
+		}
+		if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return fmt.Errorf("failed to migrate up: %v", err)
+		}
+	case "down":
+		steps, err := parseSteps(args[1:])
+		if err != nil {
+			return err
+		}
+		if steps == 0 {
+			steps = 1
+		}
+		if err := m.Steps(-steps); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return fmt.Errorf("failed to migrate down: %v", err)
+		}
+	case "version":
+	case "force":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		if err := m.Force(version); err != nil {
+			return fmt.Errorf("failed to force version: %v", err)
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		fmt.Println("No migrations applied")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	fmt.Printf("Version: %d, dirty: %t\n", version, dirty)
+	return nil
+}
+
+// parseSteps reads the optional step count of `migrate up` and `migrate
+// down`, returning 0 when it is absent.
+func parseSteps(args []string) (int, error) {
+	switch len(args) {
+	case 0:
+		return 0, nil
+	case 1:
+		steps, err := strconv.Atoi(args[0])
+		if err != nil || steps < 1 {
+			return 0, fmt.Errorf("invalid step count %q", args[0])
+		}
+		return steps, nil
+	default:
+		return 0, errors.New(migrateUsage)
+	}
+}
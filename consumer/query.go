@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"golang-assessment/golang-assessment/proto"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+func (s *TaskServiceServer) GetTask(ctx context.Context, req *proto.GetTaskRequest) (*proto.Task, error) {
+	task, err := s.store.GetTask(ctx, int(req.Id))
+	if errors.Is(err, ErrTaskNotFound) {
+		return nil, status.Errorf(codes.NotFound, "task %d not found", req.Id)
+	}
+	if err != nil {
+		logrus.Error("Failed to get task: ", err)
+		return nil, status.Errorf(codes.Internal, "failed to get task: %v", err)
+	}
+
+	return task.toProto(), nil
+}
+
+// ListTasks pages through tasks in ID order. The page token is the ID of the
+// last task of the previous page.
+func (s *TaskServiceServer) ListTasks(ctx context.Context, req *proto.ListTasksRequest) (*proto.ListTasksResponse, error) {
+	filter := TaskFilter{State: req.State}
+	if req.Type != nil {
+		taskType := int(req.GetType())
+		filter.Type = &taskType
+	}
+	if req.CreatedAfter != nil {
+		filter.CreatedAfter = req.CreatedAfter.AsTime()
+	}
+	if req.CreatedBefore != nil {
+		filter.CreatedBefore = req.CreatedBefore.AsTime()
+	}
+
+	return s.listTasks(ctx, filter, req.PageSize, req.PageToken)
+}
+
+// ListDeadTasks pages through the tasks that ran out of retries, like
+// ListTasks.
+func (s *TaskServiceServer) ListDeadTasks(ctx context.Context, req *proto.ListDeadTasksRequest) (*proto.ListTasksResponse, error) {
+	filter := TaskFilter{State: stateDead}
+	if req.Type != nil {
+		taskType := int(req.GetType())
+		filter.Type = &taskType
+	}
+
+	return s.listTasks(ctx, filter, req.PageSize, req.PageToken)
+}
+
+// listTasks returns a page of the tasks matching filter, starting after the
+// task named by pageToken.
+func (s *TaskServiceServer) listTasks(ctx context.Context, filter TaskFilter, pageSize int32, pageToken string) (*proto.ListTasksResponse, error) {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	// Fetch one extra task to find out whether another page follows.
+	filter.Limit = int(pageSize) + 1
+
+	if pageToken != "" {
+		afterID, err := strconv.ParseInt(pageToken, 10, 32)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page token %q", pageToken)
+		}
+		filter.AfterID = int(afterID)
+	}
+
+	tasks, err := s.store.ListTasks(ctx, filter)
+	if err != nil {
+		logrus.Error("Failed to list tasks: ", err)
+		return nil, status.Errorf(codes.Internal, "failed to list tasks: %v", err)
+	}
+
+	resp := &proto.ListTasksResponse{}
+	if len(tasks) > int(pageSize) {
+		tasks = tasks[:pageSize]
+		resp.NextPageToken = strconv.Itoa(tasks[len(tasks)-1].ID)
+	}
+	for _, task := range tasks {
+		resp.Tasks = append(resp.Tasks, task.toProto())
+	}
+	return resp, nil
+}
+
+// RequeueTask gives a dead task a fresh set of attempts, starting right away.
+func (s *TaskServiceServer) RequeueTask(ctx context.Context, req *proto.RequeueTaskRequest) (*proto.Task, error) {
+	task, err := s.store.GetTask(ctx, int(req.Id))
+	if errors.Is(err, ErrTaskNotFound) {
+		return nil, status.Errorf(codes.NotFound, "task %d not found", req.Id)
+	}
+	if err != nil {
+		logrus.Error("Failed to get task: ", err)
+		return nil, status.Errorf(codes.Internal, "failed to get task: %v", err)
+	}
+	if task.State != stateDead {
+		return nil, status.Errorf(codes.FailedPrecondition, "task %d is %s, not %s", req.Id, task.State, stateDead)
+	}
+
+	now := time.Now().UTC()
+	err = s.store.RequeueTask(ctx, task.ID, now)
+	if errors.Is(err, ErrTaskNotFound) {
+		// Another request requeued the task first.
+		return nil, status.Errorf(codes.FailedPrecondition, "task %d is no longer %s", req.Id, stateDead)
+	}
+	if err != nil {
+		logrus.Error("Failed to requeue task: ", err)
+		return nil, status.Errorf(codes.Internal, "failed to requeue task: %v", err)
+	}
+
+	task.State = stateRetrying
+	task.UpdatedAt = now
+	task.Attempts = 0
+	task.NextRunAt = now
+	task.Version++
+	s.metrics.trackTaskState(stateDead, task.State)
+	s.publishTransition(task, stateDead)
+
+	logrus.Infof("Task %d requeued", task.ID)
+	return task.toProto(), nil
+}
+
+func (t *Task) toProto() *proto.Task {
+	var nextRunAt *timestamppb.Timestamp
+	if !t.NextRunAt.IsZero() {
+		nextRunAt = timestamppb.New(t.NextRunAt)
+	}
+
+	return &proto.Task{
+		Id:             int32(t.ID),
+		Type:           int32(t.Type),
+		Value:          int32(t.Value),
+		State:          t.State,
+		CreationTime:   timestamppb.New(t.CreatedAt),
+		LastUpdateTime: timestamppb.New(t.UpdatedAt),
+		Comment:        t.Comment,
+		Attempts:       int32(t.Attempts),
+		NextRunAt:      nextRunAt,
+		LastError:      t.LastError,
+		Result:         t.Result,
+		Version:        int32(t.Version),
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang-assessment/shared"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// anonymousClient is the ID of clients that do not identify themselves.
+	anonymousClient = "anonymous"
+	// otherClient labels the throttled tasks of clients without a limit of
+	// their own, so that client IDs cannot blow up the label space.
+	otherClient = "other"
+
+	// limiterIdleTimeout is how long the limiter of a key is kept unused.
+	limiterIdleTimeout = 10 * time.Minute
+)
+
+// Values of the limit label of tasks_throttled_total.
+const (
+	limitClient      = "client"
+	limitType        = "type"
+	limitConcurrency = "concurrency"
+)
+
+// keyedLimiter keeps a token bucket per key, created on first use from the
+// limit configured for the key or the fallback.
+type keyedLimiter struct {
+	mu        sync.Mutex
+	fallback  shared.RateLimit
+	byKey     map[string]shared.RateLimit
+	limiters  map[string]*keyLimiter
+	lastSweep time.Time
+}
+
+type keyLimiter struct {
+	*rate.Limiter
+	lastUsed time.Time
+}
+
+func newKeyedLimiter(fallback shared.RateLimit, byKey map[string]shared.RateLimit) *keyedLimiter {
+	if byKey == nil {
+		byKey = make(map[string]shared.RateLimit)
+	}
+	return &keyedLimiter{
+		fallback: fallback,
+		byKey:    byKey,
+		limiters: make(map[string]*keyLimiter),
+	}
+}
+
+func (k *keyedLimiter) limitFor(key string) shared.RateLimit {
+	if limit, ok := k.byKey[key]; ok {
+		return limit
+	}
+	return k.fallback
+}
+
+// reserve reserves n tokens of key at now. It returns a nil reservation when
+// the key is not limited.
+func (k *keyedLimiter) reserve(key string, n int, now time.Time) *rate.Reservation {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	limit := k.limitFor(key)
+	if limit.Rate <= 0 {
+		return nil
+	}
+
+	k.sweep(now)
+	l, ok := k.limiters[key]
+	if !ok {
+		l = &keyLimiter{Limiter: rate.NewLimiter(rate.Limit(limit.Rate), max(limit.Burst, 1))}
+		k.limiters[key] = l
+	}
+	l.lastUsed = now
+	return l.ReserveN(now, n)
+}
+
+// set replaces the limits. The buckets in use keep their tokens, up to the
+// new burst, and those of keys no longer limited are dropped.
+func (k *keyedLimiter) set(fallback shared.RateLimit, byKey map[string]shared.RateLimit, now time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if byKey == nil {
+		byKey = make(map[string]shared.RateLimit)
+	}
+	k.fallback = fallback
+	k.byKey = byKey
+	for key, l := range k.limiters {
+		limit := k.limitFor(key)
+		if limit.Rate <= 0 {
+			delete(k.limiters, key)
+			continue
+		}
+		l.SetLimitAt(now, rate.Limit(limit.Rate))
+		l.SetBurstAt(now, max(limit.Burst, 1))
+	}
+}
+
+// sweep drops the limiters that have not been used for limiterIdleTimeout,
+// which are full again anyway.
+func (k *keyedLimiter) sweep(now time.Time) {
+	if now.Sub(k.lastSweep) < limiterIdleTimeout {
+		return
+	}
+	k.lastSweep = now
+	for key, l := range k.limiters {
+		if now.Sub(l.lastUsed) >= limiterIdleTimeout {
+			delete(k.limiters, key)
+		}
+	}
+}
+
+// label returns the value the key is exported under: the key itself if it
+// has a limit of its own, otherwise fallback.
+func (k *keyedLimiter) label(key, fallback string) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.byKey[key]; ok {
+		return key
+	}
+	return fallback
+}
+
+// rateLimits holds the per-client and per-type limits of a server.
+type rateLimits struct {
+	clients *keyedLimiter
+	types   *keyedLimiter
+
+	// mu guards config, the limits last set.
+	mu     sync.Mutex
+	config shared.RateLimits
+}
+
+// newRateLimits builds the limits from the config. The keys of Types are
+// task types.
+func newRateLimits(config shared.RateLimits) (*rateLimits, error) {
+	if err := validateRateLimits(config); err != nil {
+		return nil, err
+	}
+	config = cloneRateLimits(config)
+	return &rateLimits{
+		clients: newKeyedLimiter(config.PerClient, config.Clients),
+		types:   newKeyedLimiter(config.PerType, config.Types),
+		config:  config,
+	}, nil
+}
+
+func validateRateLimits(config shared.RateLimits) error {
+	check := func(name string, limit shared.RateLimit) error {
+		if math.IsNaN(limit.Rate) || math.IsInf(limit.Rate, 0) {
+			return fmt.Errorf("invalid rate %v in %s", limit.Rate, name)
+		}
+		if limit.Burst < 0 {
+			return fmt.Errorf("negative burst %d in %s", limit.Burst, name)
+		}
+		return nil
+	}
+
+	if err := check("RateLimits.PerClient", config.PerClient); err != nil {
+		return err
+	}
+	if err := check("RateLimits.PerType", config.PerType); err != nil {
+		return err
+	}
+	for client, limit := range config.Clients {
+		if err := check(fmt.Sprintf("RateLimits.Clients[%q]", client), limit); err != nil {
+			return err
+		}
+	}
+	for key, limit := range config.Types {
+		if _, err := strconv.Atoi(key); err != nil {
+			return fmt.Errorf("invalid task type %q in RateLimits.Types", key)
+		}
+		if err := check(fmt.Sprintf("RateLimits.Types[%q]", key), limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cloneRateLimits copies the maps of config, so that it can be kept.
+func cloneRateLimits(config shared.RateLimits) shared.RateLimits {
+	config.Clients = maps.Clone(config.Clients)
+	config.Types = maps.Clone(config.Types)
+	return config
+}
+
+// current returns the limits last set.
+func (l *rateLimits) current() shared.RateLimits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return cloneRateLimits(l.config)
+}
+
+// set replaces the limits, leaving them unchanged if config is invalid.
+func (l *rateLimits) set(config shared.RateLimits) error {
+	if err := validateRateLimits(config); err != nil {
+		return err
+	}
+	config = cloneRateLimits(config)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.clients.set(config.PerClient, config.Clients, now)
+	l.types.set(config.PerType, config.Types, now)
+	l.config = config
+	return nil
+}
+
+// clientID identifies the client sending a request: the common name of its
+// verified TLS certificate, else the client ID in its metadata, else
+// anonymousClient.
+func clientID(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			if chains := info.State.VerifiedChains; len(chains) > 0 && len(chains[0]) > 0 {
+				if cn := chains[0][0].Subject.CommonName; cn != "" {
+					return cn
+				}
+			}
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(shared.ClientIDMetadataKey); len(ids) > 0 && ids[0] != "" {
+			return ids[0]
+		}
+	}
+	return anonymousClient
+}
+
+// checkRateLimits admits the tasks of a request, given by type, or rejects
+// them all with ResourceExhausted. When waiting can help, the trailer tells
+// the client how long to wait before it tries again. The returned function
+// gives the tokens back and must be called if the tasks are rejected later.
+func (s *TaskServiceServer) checkRateLimits(ctx context.Context, types []int) (cancel func(), err error) {
+	ctx, span := tracer().Start(ctx, "rateLimit")
+	defer func() { endSpan(span, err) }()
+
+	client := clientID(ctx)
+	span.SetAttributes(attribute.String("client.id", client), attribute.Int("tasks.count", len(types)))
+
+	counts := make(map[int]int)
+	for _, taskType := range types {
+		counts[taskType]++
+	}
+	sortedTypes := make([]int, 0, len(counts))
+	for taskType := range counts {
+		sortedTypes = append(sortedTypes, taskType)
+	}
+	sort.Ints(sortedTypes)
+
+	now := time.Now()
+	var reservations []*rate.Reservation
+	var delay time.Duration
+	var limit string
+	exceeded := false
+
+	check := func(r *rate.Reservation, name string) {
+		if r == nil {
+			return
+		}
+		reservations = append(reservations, r)
+		switch {
+		case !r.OK():
+			// More tasks than the burst: no wait can admit them.
+			exceeded = true
+			limit = name
+		case r.DelayFrom(now) > delay:
+			delay = r.DelayFrom(now)
+			if !exceeded {
+				limit = name
+			}
+		}
+	}
+
+	check(s.limits.clients.reserve(client, len(types), now), limitClient)
+	for _, taskType := range sortedTypes {
+		check(s.limits.types.reserve(strconv.Itoa(taskType), counts[taskType], now), limitType)
+	}
+	if !exceeded && delay == 0 {
+		// Cancel as of now: tokens are only given back for a reservation
+		// cancelled no later than its time to act.
+		return func() {
+			for _, r := range reservations {
+				r.CancelAt(now)
+			}
+		}, nil
+	}
+
+	for _, r := range reservations {
+		r.CancelAt(now)
+	}
+	s.recordThrottled(limit, client, types)
+
+	if exceeded {
+		return nil, status.Errorf(codes.ResourceExhausted, "%d tasks exceed the %s rate limit burst", len(types), limit)
+	}
+	retryAfter := max(delay.Milliseconds(), 1)
+	grpc.SetTrailer(ctx, metadata.Pairs(shared.RetryAfterMetadataKey, strconv.FormatInt(retryAfter, 10)))
+	return nil, status.Errorf(codes.ResourceExhausted, "%s rate limit exceeded, retry after %dms", limit, retryAfter)
+}
+
+// recordThrottled counts the tasks of the given types rejected by limit.
+func (s *TaskServiceServer) recordThrottled(limit, client string, types []int) {
+	clientLabel := s.limits.clients.label(client, otherClient)
+	for _, taskType := range types {
+		s.metrics.tasksThrottled.WithLabelValues(limit, clientLabel, strconv.Itoa(taskType)).Inc()
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang-assessment/golang-assessment/proto"
+	"golang-assessment/shared"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestClientID(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "producer-1"}}
+	tlsPeer := &peer.Peer{AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{cert}},
+	}}}
+	withID := metadata.NewIncomingContext(context.Background(), metadata.Pairs(shared.ClientIDMetadataKey, "batch-job"))
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"anonymous", context.Background(), anonymousClient},
+		{"metadata", withID, "batch-job"},
+		{"certificate wins", peer.NewContext(withID, tlsPeer), "producer-1"},
+	}
+	for _, tt := range tests {
+		if got := clientID(tt.ctx); got != tt.want {
+			t.Errorf("%s: expected client %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestNewRateLimitsRejectsInvalidType(t *testing.T) {
+	if _, err := newRateLimits(shared.RateLimits{Types: map[string]shared.RateLimit{"two": {Rate: 1}}}); err == nil {
+		t.Errorf("Expected an error for a non-numeric task type")
+	}
+}
+
+// sendAs sends a task as the client and returns the retry-after trailer.
+func sendAs(client proto.TaskServiceClient, id string, taskType int32) (string, error) {
+	ctx := context.Background()
+	if id != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, shared.ClientIDMetadataKey, id)
+	}
+	var trailer metadata.MD
+	_, err := client.SendTask(ctx, &proto.TaskRequest{Type: taskType, Value: 1}, grpc.Trailer(&trailer))
+	return strings.Join(trailer.Get(shared.RetryAfterMetadataKey), ","), err
+}
+
+func TestPerClientRateLimit(t *testing.T) {
+	s, _ := newTestServer(t)
+	var err error
+	s.limits, err = newRateLimits(shared.RateLimits{
+		PerClient: shared.RateLimit{Rate: 0.01, Burst: 1},
+		Clients:   map[string]shared.RateLimit{"a": {Rate: 0.01, Burst: 2}},
+	})
+	if err != nil {
+		t.Fatalf("Error building rate limits: %v", err)
+	}
+	client := startTestGRPCServer(t, s)
+
+	for i := 0; i < 2; i++ {
+		if _, err := sendAs(client, "a", 1); err != nil {
+			t.Fatalf("Expected task %d of client a within its burst, got %v", i, err)
+		}
+	}
+	retryAfter, err := sendAs(client, "a", 1)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Expected ResourceExhausted past the burst, got %v", err)
+	}
+	if ms, convErr := strconv.Atoi(retryAfter); convErr != nil || ms <= 0 {
+		t.Errorf("Expected a positive retry-after, got %q", retryAfter)
+	}
+
+	// Other clients have buckets of their own.
+	if _, err := sendAs(client, "b", 1); err != nil {
+		t.Errorf("Expected client b to be admitted, got %v", err)
+	}
+	if _, err := sendAs(client, "b", 1); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected client b to be limited by PerClient, got %v", err)
+	}
+	if _, err := sendAs(client, "", 1); err != nil {
+		t.Errorf("Expected an anonymous client to be admitted, got %v", err)
+	}
+
+	expected := `
+# HELP tasks_throttled_total Number of tasks rejected by the rate and concurrency limits, by the limit hit, client and task type
+# TYPE tasks_throttled_total counter
+tasks_throttled_total{client="a",limit="client",type="1"} 1
+tasks_throttled_total{client="other",limit="client",type="1"} 1
+`
+	if err := testutil.CollectAndCompare(s.metrics.tasksThrottled, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPerTypeRateLimit(t *testing.T) {
+	s, _ := newTestServer(t)
+	var err error
+	s.limits, err = newRateLimits(shared.RateLimits{
+		PerType: shared.RateLimit{Rate: 0.01, Burst: 1},
+		Types:   map[string]shared.RateLimit{"2": {}},
+	})
+	if err != nil {
+		t.Fatalf("Error building rate limits: %v", err)
+	}
+	client := startTestGRPCServer(t, s)
+
+	if _, err := sendAs(client, "a", 1); err != nil {
+		t.Fatalf("Expected the first task of type 1 to be admitted, got %v", err)
+	}
+	// The type limit is shared by every client.
+	if _, err := sendAs(client, "b", 1); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected the second task of type 1 to be throttled, got %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := sendAs(client, "b", 2); err != nil {
+			t.Errorf("Expected type 2 to be unlimited, got %v", err)
+		}
+	}
+}
+
+func TestSendTasksRateLimit(t *testing.T) {
+	s, store := newTestServer(t)
+	var err error
+	s.limits, err = newRateLimits(shared.RateLimits{PerClient: shared.RateLimit{Rate: 0.01, Burst: 2}})
+	if err != nil {
+		t.Fatalf("Error building rate limits: %v", err)
+	}
+	client := startTestGRPCServer(t, s)
+	ctx := context.Background()
+
+	sendBatch := func(n int) (string, error) {
+		stream, err := client.SendTasks(ctx)
+		if err != nil {
+			return "", err
+		}
+		for i := 0; i < n; i++ {
+			if err := stream.Send(&proto.TaskRequest{Type: 1, Value: 1}); err != nil {
+				break
+			}
+		}
+		_, err = stream.CloseAndRecv()
+		return strings.Join(stream.Trailer().Get(shared.RetryAfterMetadataKey), ","), err
+	}
+
+	retryAfter, err := sendBatch(3)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Expected a batch larger than the burst to be rejected, got %v", err)
+	}
+	if retryAfter != "" {
+		t.Errorf("Expected no retry-after for a batch that can never fit, got %q", retryAfter)
+	}
+
+	// The rejected batch took no tokens.
+	if _, err := sendBatch(2); err != nil {
+		t.Fatalf("Expected a batch within the burst to be admitted, got %v", err)
+	}
+	if retryAfter, err := sendBatch(1); status.Code(err) != codes.ResourceExhausted || retryAfter == "" {
+		t.Errorf("Expected ResourceExhausted with retry-after once the burst is spent, got %q, %v", retryAfter, err)
+	}
+
+	counts, err := store.CountTasksByState(ctx)
+	if err != nil {
+		t.Fatalf("Error counting tasks: %v", err)
+	}
+	if counts[stateReceived] != 2 {
+		t.Errorf("Expected only the admitted batch to be stored, got %v", counts)
+	}
+}
+
+func TestRateLimitTokensReturnedWhenQueueIsFull(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.queue = make(chan *Task, 1)
+	var err error
+	s.limits, err = newRateLimits(shared.RateLimits{PerClient: shared.RateLimit{Rate: 0.01, Burst: 2}})
+	if err != nil {
+		t.Fatalf("Error building rate limits: %v", err)
+	}
+	client := startTestGRPCServer(t, s)
+
+	if _, err := sendAs(client, "a", 1); err != nil {
+		t.Fatalf("Expected the first task to be admitted, got %v", err)
+	}
+	if _, err := sendAs(client, "a", 1); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Expected ResourceExhausted on a full queue, got %v", err)
+	}
+
+	// The task turned away by the full queue gave its token back.
+	<-s.queue
+	s.releaseQueue(1)
+	if _, err := sendAs(client, "a", 1); err != nil {
+		t.Errorf("Expected the token of the rejected task to be returned, got %v", err)
+	}
+}
+
+func TestKeyedLimiterSet(t *testing.T) {
+	k := newKeyedLimiter(shared.RateLimit{Rate: 0.01, Burst: 1}, nil)
+	now := time.Now()
+
+	if r := k.reserve("a", 1, now); r == nil || r.DelayFrom(now) != 0 {
+		t.Fatalf("Expected the first token to be available")
+	}
+	if r := k.reserve("a", 1, now); r.DelayFrom(now) == 0 {
+		t.Fatalf("Expected the bucket to be empty")
+	}
+
+	// A higher rate refills the bucket in use sooner.
+	k.set(shared.RateLimit{Rate: 1000, Burst: 1}, nil, now)
+	later := now.Add(10 * time.Millisecond)
+	if r := k.reserve("a", 1, later); r.DelayFrom(later) != 0 {
+		t.Errorf("Expected the new rate to refill the bucket, got a delay of %v", r.DelayFrom(later))
+	}
+
+	k.set(shared.RateLimit{}, map[string]shared.RateLimit{"b": {Rate: 1, Burst: 1}}, later)
+	if r := k.reserve("a", 100, later); r != nil {
+		t.Errorf("Expected key a to be unlimited")
+	}
+	if r := k.reserve("b", 2, later); r.OK() {
+		t.Errorf("Expected key b to be limited to a burst of 1")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"golang-assessment/shared"
+
+	"github.com/sirupsen/logrus"
+)
+
+// retryPollInterval is how often the scheduler looks for retries that are due.
+const retryPollInterval = time.Second
+
+// defaultRetryPolicy fills in every setting a configured policy leaves out.
+var defaultRetryPolicy = shared.RetryPolicy{
+	MaxAttempts:      3,
+	InitialBackoffMs: 1000,
+	MaxBackoffMs:     60000,
+	Multiplier:       2,
+}
+
+// retryPolicies holds the retry policy for each task type.
+type retryPolicies struct {
+	fallback shared.RetryPolicy
+	byType   map[int]shared.RetryPolicy
+}
+
+// newRetryPolicies builds the policies from the config. The keys of byType
+// are task types, and types without an entry use fallback.
+func newRetryPolicies(fallback shared.RetryPolicy, byType map[string]shared.RetryPolicy) (*retryPolicies, error) {
+	p := &retryPolicies{
+		fallback: withRetryDefaults(fallback, defaultRetryPolicy),
+		byType:   make(map[int]shared.RetryPolicy, len(byType)),
+	}
+	for key, policy := range byType {
+		taskType, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid task type %q in RetryByType", key)
+		}
+		p.byType[taskType] = withRetryDefaults(policy, p.fallback)
+	}
+	return p, nil
+}
+
+// withRetryDefaults replaces the unset fields of policy with those of def.
+func withRetryDefaults(policy, def shared.RetryPolicy) shared.RetryPolicy {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = def.MaxAttempts
+	}
+	if policy.InitialBackoffMs <= 0 {
+		policy.InitialBackoffMs = def.InitialBackoffMs
+	}
+	if policy.MaxBackoffMs <= 0 {
+		policy.MaxBackoffMs = def.MaxBackoffMs
+	}
+	if policy.Multiplier < 1 {
+		policy.Multiplier = def.Multiplier
+	}
+	return policy
+}
+
+func (p *retryPolicies) forType(taskType int) shared.RetryPolicy {
+	if policy, ok := p.byType[taskType]; ok {
+		return policy
+	}
+	return p.fallback
+}
+
+// backoff returns how long to wait before the attempt following the given
+// number of failed attempts.
+func backoff(policy shared.RetryPolicy, attempts int) time.Duration {
+	ms := float64(policy.InitialBackoffMs) * math.Pow(policy.Multiplier, float64(attempts-1))
+	if ms > float64(policy.MaxBackoffMs) {
+		ms = float64(policy.MaxBackoffMs)
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// recordFailure counts a failed attempt at the task and either schedules the
+// next attempt or, once the task has run out of attempts, moves it to dead.
+func (s *TaskServiceServer) recordFailure(ctx context.Context, task *Task, cause error) error {
+	policy := s.retry.forType(task.Type)
+	now := time.Now().UTC()
+
+	failed := *task
+	failed.Attempts++
+	failed.UpdatedAt = now
+	failed.LastError = cause.Error()
+	failed.Version++
+	if failed.Attempts >= policy.MaxAttempts {
+		failed.State = stateDead
+		failed.NextRunAt = time.Time{}
+	} else {
+		failed.State = stateRetrying
+		failed.NextRunAt = now.Add(backoff(policy, failed.Attempts))
+	}
+
+	if err := s.store.RecordTaskFailure(ctx, &failed); err != nil {
+		return err
+	}
+
+	previous := task.State
+	*task = failed
+	s.metrics.trackTaskState(previous, task.State)
+	s.publishTransition(task, previous)
+
+	if task.State == stateDead {
+		logrus.Warnf("Task %d is dead after %d attempts: %v", task.ID, task.Attempts, cause)
+	} else {
+		logrus.Warnf("Task %d failed attempt %d, retrying at %s: %v", task.ID, task.Attempts, task.NextRunAt.Format(time.RFC3339), cause)
+	}
+	return nil
+}
+
+// scheduleRetries hands due retries back to the workers until ctx is
+// cancelled or the server is stopping.
+func (s *TaskServiceServer) scheduleRetries(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(retryPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stopping:
+			return
+		case <-ticker.C:
+			if err := s.scheduleDueRetries(ctx); err != nil {
+				logrus.Error("Failed to schedule retries: ", err)
+			}
+		}
+	}
+}
+
+// scheduleDueRetries moves as many due retries back to received as there is
+// room for in the queue and enqueues them.
+func (s *TaskServiceServer) scheduleDueRetries(ctx context.Context) error {
+	room := s.queueRoom()
+	if room <= 0 {
+		return nil
+	}
+
+	tasks, err := s.store.ListDueTasks(ctx, time.Now().UTC(), room)
+	if err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		if !s.reserveQueue(1) {
+			// Leave the rest for the next poll, keeping their next_run_at.
+			return nil
+		}
+		if err := s.updateTaskState(ctx, task, stateReceived); err != nil {
+			s.releaseQueue(1)
+			return err
+		}
+		id := task.ID
+		s.enqueue(task)
+		logrus.Infof("Retrying task %d", id)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang-assessment/golang-assessment/proto"
+	"golang-assessment/shared"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := shared.RetryPolicy{MaxAttempts: 10, InitialBackoffMs: 100, MaxBackoffMs: 1000, Multiplier: 3}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 300 * time.Millisecond},
+		{3, 900 * time.Millisecond},
+		{4, time.Second},
+		{9, time.Second},
+	}
+	for _, tt := range tests {
+		if got := backoff(policy, tt.attempts); got != tt.want {
+			t.Errorf("backoff after %d attempts: expected %v, got %v", tt.attempts, tt.want, got)
+		}
+	}
+}
+
+func TestNewRetryPolicies(t *testing.T) {
+	policies, err := newRetryPolicies(
+		shared.RetryPolicy{MaxAttempts: 5},
+		map[string]shared.RetryPolicy{"2": {MaxAttempts: 1, InitialBackoffMs: 10}},
+	)
+	if err != nil {
+		t.Fatalf("Error building retry policies: %v", err)
+	}
+
+	fallback := shared.RetryPolicy{MaxAttempts: 5, InitialBackoffMs: 1000, MaxBackoffMs: 60000, Multiplier: 2}
+	if got := policies.forType(1); got != fallback {
+		t.Errorf("Expected policy %+v for type 1, got %+v", fallback, got)
+	}
+	want := shared.RetryPolicy{MaxAttempts: 1, InitialBackoffMs: 10, MaxBackoffMs: 60000, Multiplier: 2}
+	if got := policies.forType(2); got != want {
+		t.Errorf("Expected policy %+v for type 2, got %+v", want, got)
+	}
+
+	if _, err := newRetryPolicies(shared.RetryPolicy{}, map[string]shared.RetryPolicy{"two": {}}); err == nil {
+		t.Errorf("Expected an error for a non-numeric task type")
+	}
+}
+
+func TestFailingTaskIsRetriedUntilDead(t *testing.T) {
+	s, store := newTestServer(t)
+	s.handlers.Register(1, HandlerFunc(func(context.Context, *Task) (string, error) {
+		return "", errors.New("boom")
+	}))
+	s.retry = &retryPolicies{fallback: shared.RetryPolicy{MaxAttempts: 2, InitialBackoffMs: 1, MaxBackoffMs: 1, Multiplier: 1}}
+
+	ctx := context.Background()
+	task := newTask(&proto.TaskRequest{Type: 1, Value: 1})
+	if err := s.SaveTask(ctx, task); err != nil {
+		t.Fatalf("Error saving task: %v", err)
+	}
+
+	s.processTask(ctx, task)
+
+	saved, err := store.GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatalf("Error getting task: %v", err)
+	}
+	if saved.State != stateRetrying || saved.Attempts != 1 || saved.LastError != "boom" || saved.NextRunAt.IsZero() {
+		t.Fatalf("Unexpected task after first failure: %+v", saved)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if err := s.scheduleDueRetries(ctx); err != nil {
+		t.Fatalf("Error scheduling retries: %v", err)
+	}
+	select {
+	case queued := <-s.queue:
+		if queued.ID != task.ID || queued.State != stateReceived {
+			t.Fatalf("Unexpected queued task: %+v", queued)
+		}
+		s.processTask(ctx, queued)
+	default:
+		t.Fatalf("Expected the due retry to be queued")
+	}
+
+	saved, err = store.GetTask(ctx, task.ID)
+	if err != nil {
+		t.Fatalf("Error getting task: %v", err)
+	}
+	if saved.State != stateDead || saved.Attempts != 2 {
+		t.Fatalf("Unexpected task after last failure: %+v", saved)
+	}
+}
+
+func TestListDeadTasksAndRequeueTask(t *testing.T) {
+	s, store := newTestServer(t)
+	ctx := context.Background()
+
+	var ids []int
+	for i := 0; i < 3; i++ {
+		task := newTask(&proto.TaskRequest{Type: int32(i), Value: 1})
+		if err := s.SaveTask(ctx, task); err != nil {
+			t.Fatalf("Error saving task: %v", err)
+		}
+		ids = append(ids, task.ID)
+	}
+	for _, id := range ids[1:] {
+		dead := &Task{ID: id, State: stateDead, UpdatedAt: time.Now().UTC(), Attempts: 3, LastError: "boom"}
+		if err := store.RecordTaskFailure(ctx, dead); err != nil {
+			t.Fatalf("Error recording task failure: %v", err)
+		}
+	}
+
+	client := startTestGRPCServer(t, s)
+
+	resp, err := client.ListDeadTasks(ctx, &proto.ListDeadTasksRequest{PageSize: 1})
+	if err != nil {
+		t.Fatalf("Error in ListDeadTasks: %v", err)
+	}
+	if len(resp.Tasks) != 1 || resp.Tasks[0].Id != int32(ids[1]) || resp.NextPageToken == "" {
+		t.Fatalf("Unexpected first page: %v", resp)
+	}
+	if resp.Tasks[0].Attempts != 3 || resp.Tasks[0].LastError != "boom" {
+		t.Errorf("Expected attempts and last error on dead task, got %v", resp.Tasks[0])
+	}
+
+	resp, err = client.ListDeadTasks(ctx, &proto.ListDeadTasksRequest{PageToken: resp.NextPageToken})
+	if err != nil {
+		t.Fatalf("Error in ListDeadTasks: %v", err)
+	}
+	if len(resp.Tasks) != 1 || resp.Tasks[0].Id != int32(ids[2]) || resp.NextPageToken != "" {
+		t.Fatalf("Unexpected second page: %v", resp)
+	}
+
+	requeued, err := client.RequeueTask(ctx, &proto.RequeueTaskRequest{Id: int32(ids[1])})
+	if err != nil {
+		t.Fatalf("Error in RequeueTask: %v", err)
+	}
+	if requeued.State != stateRetrying || requeued.Attempts != 0 || requeued.NextRunAt == nil {
+		t.Errorf("Unexpected requeued task: %v", requeued)
+	}
+
+	due, err := store.ListDueTasks(ctx, time.Now().UTC(), 10)
+	if err != nil {
+		t.Fatalf("Error listing due tasks: %v", err)
+	}
+	if len(due) != 1 || due[0].ID != ids[1] {
+		t.Errorf("Expected task %d to be due, got %v", ids[1], due)
+	}
+
+	tests := []struct {
+		name string
+		id   int
+		code codes.Code
+	}{
+		{"not dead", ids[0], codes.FailedPrecondition},
+		{"already requeued", ids[1], codes.FailedPrecondition},
+		{"unknown", ids[2] + 1000, codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.RequeueTask(ctx, &proto.RequeueTaskRequest{Id: int32(tt.id)})
+			if status.Code(err) != tt.code {
+				t.Errorf("Expected %v, got %v", tt.code, err)
+			}
+		})
+	}
+}
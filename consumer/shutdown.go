@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errDraining rejects tasks sent while the consumer shuts down.
+var errDraining = status.Error(codes.Unavailable, "consumer is shutting down")
+
+// resumePageSize is how many unfinished tasks resumeTasks reads at a time.
+const resumePageSize = 500
+
+// startAdmission registers a request that may admit tasks, and reports false
+// once the server is draining.
+func (s *TaskServiceServer) startAdmission() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.draining {
+		return false
+	}
+	s.admissions.Add(1)
+	return true
+}
+
+// Shutdown stops admitting tasks and waits for the admissions in flight, then
+// lets the workers finish every queued task before they stop. When ctx
+// expires first the workers are interrupted: the tasks they were processing
+// go back to received, like those still queued, for the next start to resume.
+func (s *TaskServiceServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	alreadyDraining := s.draining
+	s.draining = true
+	s.mu.Unlock()
+	if alreadyDraining {
+		return nil
+	}
+
+	err := waitOrExpire(ctx, s.admissions.Wait)
+	close(s.stopping)
+	if err == nil {
+		err = waitOrExpire(ctx, s.wg.Wait)
+	}
+	if err == nil {
+		return nil
+	}
+
+	logrus.Warnf("Shutdown deadline passed with %d tasks queued, interrupting workers", len(s.queue))
+	if s.stopWorkers != nil {
+		s.stopWorkers()
+	}
+	s.wg.Wait()
+	return err
+}
+
+// waitOrExpire calls wait and returns once it does, or with the error of ctx
+// if that expires first.
+func waitOrExpire(ctx context.Context, wait func()) error {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// next returns the next queued task for a worker, and false once the worker
+// should stop: when ctx is cancelled, or when the server is stopping and the
+// queue is empty.
+func (s *TaskServiceServer) next(ctx context.Context) (*Task, bool) {
+	select {
+	case <-ctx.Done():
+		return nil, false
+	case task := <-s.queue:
+		s.releaseQueue(1)
+		return task, ctx.Err() == nil
+	case <-s.stopping:
+		select {
+		case task := <-s.queue:
+			s.releaseQueue(1)
+			return task, ctx.Err() == nil
+		default:
+			return nil, false
+		}
+	}
+}
+
+// resumeTasks hands the tasks a previous run left unfinished back to the
+// workers: those still received, and those it was processing when it stopped
+// without draining, which go back to received first. It reads them before
+// returning, so it must run before the server admits new tasks and before
+// StartWorkers starts the retry scheduler, and queues them in the background
+// as the workers make room.
+func (s *TaskServiceServer) resumeTasks(ctx context.Context) error {
+	orphaned, err := s.listAllTasks(ctx, stateProcessing)
+	if err != nil {
+		return err
+	}
+	for _, task := range orphaned {
+		if err := s.updateTaskState(ctx, task, stateReceived); err != nil {
+			return err
+		}
+	}
+
+	tasks, err := s.listAllTasks(ctx, stateReceived)
+	if err != nil {
+		return err
+	}
+	if len(tasks) == 0 {
+		return nil
+	}
+	logrus.Infof("Resuming %d unfinished tasks", len(tasks))
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for _, task := range tasks {
+			if !s.enqueueWhenRoom(ctx, task) {
+				// The rest stay received for the next start.
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// listAllTasks returns every task in the state.
+func (s *TaskServiceServer) listAllTasks(ctx context.Context, state string) ([]*Task, error) {
+	var all []*Task
+	filter := TaskFilter{State: state, Limit: resumePageSize}
+	for {
+		tasks, err := s.store.ListTasks(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, tasks...)
+		if len(tasks) < filter.Limit {
+			return all, nil
+		}
+		filter.AfterID = tasks[len(tasks)-1].ID
+	}
+}
+
+// stopGRPCServer stops the server gracefully, letting running RPCs finish,
+// and forces it to stop if ctx expires first. It returns once the server
+// has stopped.
+func stopGRPCServer(ctx context.Context, server *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logrus.Warn("Shutdown deadline passed, closing the remaining RPCs")
+		server.Stop()
+		<-done
+	}
+}
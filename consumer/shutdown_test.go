@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang-assessment/golang-assessment/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// admitTasks saves and queues n tasks of the type.
+func admitTasks(t *testing.T, s *TaskServiceServer, taskType, n int) []int {
+	t.Helper()
+
+	ctx := context.Background()
+	var ids []int
+	for i := 0; i < n; i++ {
+		if !s.reserveQueue(1) {
+			t.Fatalf("Expected room in the queue for task %d", i)
+		}
+		task := newTask(&proto.TaskRequest{Type: int32(taskType), Value: 1})
+		if err := s.SaveTask(ctx, task); err != nil {
+			t.Fatalf("Error saving task: %v", err)
+		}
+		id := task.ID
+		s.admit(ctx, task)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func expectStates(t *testing.T, store TaskStore, ids []int, state string) {
+	t.Helper()
+
+	for _, id := range ids {
+		task, err := store.GetTask(context.Background(), id)
+		if err != nil {
+			t.Fatalf("Error getting task %d: %v", id, err)
+		}
+		if task.State != state {
+			t.Errorf("Expected task %d to be %s, got %s", id, state, task.State)
+		}
+	}
+}
+
+func TestShutdownDrainsQueuedTasks(t *testing.T) {
+	s, store := newTestServer(t)
+	ids := admitTasks(t, s, 1, 5)
+
+	s.StartWorkers(context.Background(), 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Error shutting down: %v", err)
+	}
+
+	expectStates(t, store, ids, stateDone)
+
+	client := startTestGRPCServer(t, s)
+	if _, err := client.SendTask(ctx, &proto.TaskRequest{Type: 1, Value: 1}); status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected Unavailable from SendTask while draining, got %v", err)
+	}
+}
+
+func TestShutdownDeadlineReturnsTasksToReceived(t *testing.T) {
+	s, store := newTestServer(t)
+	started := make(chan struct{}, 1)
+	s.handlers.Register(1, HandlerFunc(func(ctx context.Context, _ *Task) (string, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return "", ctx.Err()
+	}))
+	ids := admitTasks(t, s, 1, 2)
+
+	s.StartWorkers(context.Background(), 1)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the shutdown deadline to pass, got %v", err)
+	}
+
+	// The interrupted task and the one still queued are both resumable.
+	expectStates(t, store, ids, stateReceived)
+
+	task, err := store.GetTask(context.Background(), ids[0])
+	if err != nil {
+		t.Fatalf("Error getting task: %v", err)
+	}
+	if task.Attempts != 0 {
+		t.Errorf("Expected an interrupted task not to count as a failed attempt, got %d", task.Attempts)
+	}
+}
+
+func TestResumeTasks(t *testing.T) {
+	s, store := newTestServer(t)
+	ctx := context.Background()
+
+	ids := make([]int, 3)
+	for i, state := range []string{stateReceived, stateProcessing, stateDone} {
+		task := newTask(&proto.TaskRequest{Type: 1, Value: 1})
+		if err := s.SaveTask(ctx, task); err != nil {
+			t.Fatalf("Error saving task: %v", err)
+		}
+		if err := store.UpdateTaskState(ctx, task.ID, state, task.UpdatedAt); err != nil {
+			t.Fatalf("Error updating task state: %v", err)
+		}
+		ids[i] = task.ID
+	}
+
+	if err := s.resumeTasks(ctx); err != nil {
+		t.Fatalf("Error resuming tasks: %v", err)
+	}
+	expectStates(t, store, ids[:2], stateReceived)
+
+	for _, id := range ids[:2] {
+		select {
+		case task := <-s.queue:
+			if task.ID != id || task.State != stateReceived {
+				t.Errorf("Expected task %d to be queued as received, got %+v", id, task)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected task %d to be queued", id)
+		}
+	}
+	select {
+	case task := <-s.queue:
+		t.Errorf("Expected only unfinished tasks to be queued, got %+v", task)
+	default:
+	}
+}
+
+func TestWatchEndsOnShutdown(t *testing.T) {
+	s, _ := newTestServer(t)
+	client := startTestGRPCServer(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.WatchTasks(ctx, &proto.WatchTasksRequest{})
+	if err != nil {
+		t.Fatalf("Error in WatchTasks: %v", err)
+	}
+	// Watches started after closing end the same way, so there is no need
+	// to wait for this one to subscribe.
+	s.events.close()
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected Unavailable once the server shuts down, got %v", err)
+	}
+}
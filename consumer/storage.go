@@ -0,0 +1,101 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	taskdb "golang-assessment/shared/db"
+	"golang-assessment/shared/db/postgres"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	engineSQLite   = "sqlite"
+	enginePostgres = "postgres"
+)
+
+// defaultDatabaseURL is used when the config does not name a database.
+const defaultDatabaseURL = "sqlite:///app/data/tasks.db"
+
+// sqliteFilePragmas let the workers, the admission path and the retry
+// scheduler write to a file database at the same time: WAL keeps readers off
+// the writer's lock and the busy timeout makes a writer wait for the lock
+// instead of failing with SQLITE_BUSY.
+const sqliteFilePragmas = "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+
+// storage is the database the consumer keeps its tasks in. The engine is
+// picked from the scheme of the database URL.
+type storage struct {
+	engine string
+	db     *sql.DB
+}
+
+// openStorage opens the database named by url. sqlite:// URLs carry a file
+// path or :memory:, postgres:// and postgresql:// URLs are handed to pgx.
+func openStorage(url string) (*storage, error) {
+	scheme, rest, ok := strings.Cut(url, "://")
+	if !ok {
+		return nil, fmt.Errorf("invalid database URL %q", url)
+	}
+
+	switch scheme {
+	case "sqlite":
+		db, err := openSQLite(rest)
+		if err != nil {
+			return nil, err
+		}
+		return &storage{engine: engineSQLite, db: db}, nil
+	case "postgres", "postgresql":
+		db, err := sql.Open("pgx", url)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to the database: %v", err)
+		}
+		return &storage{engine: enginePostgres, db: db}, nil
+	default:
+		return nil, fmt.Errorf("unsupported database URL scheme %q", scheme)
+	}
+}
+
+// openSQLite opens the SQLite database at path, creating the file if it does
+// not exist yet.
+func openSQLite(path string) (*sql.DB, error) {
+	dsn := path
+	if path != ":memory:" {
+		_, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			file, err := os.Create(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create the database file: %v", err)
+			}
+			file.Close()
+			log.Printf("Database file created: %s\n", path)
+		} else if err != nil {
+			return nil, fmt.Errorf("error checking the database file: %v", err)
+		}
+		dsn = path + "?" + sqliteFilePragmas
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the database: %v", err)
+	}
+	return db, nil
+}
+
+// queries returns the generated queries for the storage engine, running on
+// dbtx, which is either the database itself or a transaction.
+func (st *storage) queries(dbtx taskdb.DBTX) taskdb.Querier {
+	if st.engine == enginePostgres {
+		return postgres.NewQuerier(dbtx)
+	}
+	return taskdb.New(dbtx)
+}
+
+func (st *storage) Close() error {
+	return st.db.Close()
+}
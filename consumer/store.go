@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang-assessment/shared"
+)
+
+// ErrTaskNotFound is returned by a TaskStore for an unknown task ID.
+var ErrTaskNotFound = errors.New("task not found")
+
+// TaskStore persists tasks for the server and its workers.
+type TaskStore interface {
+	// CreateTask stores a new task and sets its ID.
+	CreateTask(ctx context.Context, task *Task) error
+	// CreateTasks stores all tasks or none of them and sets their IDs.
+	CreateTasks(ctx context.Context, tasks []*Task) error
+	// GetTask returns the task with the given ID or ErrTaskNotFound.
+	GetTask(ctx context.Context, id int) (*Task, error)
+	// UpdateTaskState records that the task moved to state at updatedAt.
+	UpdateTaskState(ctx context.Context, id int, state string, updatedAt time.Time) error
+	// RecordTaskResult records the state and handler result of a task that
+	// is done.
+	RecordTaskResult(ctx context.Context, task *Task) error
+	// RecordTaskFailure records the state, attempts, next run time and last
+	// error of a task whose handler failed.
+	RecordTaskFailure(ctx context.Context, task *Task) error
+	// ListDueTasks returns up to limit retrying tasks whose next run time is
+	// not after now, those due first.
+	ListDueTasks(ctx context.Context, now time.Time, limit int) ([]*Task, error)
+	// RequeueTask moves a dead task back to retrying, due at updatedAt with
+	// its attempts reset. It returns ErrTaskNotFound if no dead task has
+	// the given ID.
+	RequeueTask(ctx context.Context, id int, updatedAt time.Time) error
+	// ListTasks returns the tasks matching the filter in ID order.
+	ListTasks(ctx context.Context, filter TaskFilter) ([]*Task, error)
+	// CountTasksByState returns the number of tasks in each state that has
+	// any tasks.
+	CountTasksByState(ctx context.Context) (map[string]int, error)
+}
+
+// RateLimitStore keeps the audit log of the rate limits applied at runtime.
+// The latest change holds the limits in force.
+type RateLimitStore interface {
+	// RecordRateLimitChange appends the change to the log and sets its ID.
+	RecordRateLimitChange(ctx context.Context, change *RateLimitChange) error
+	// ListRateLimitChanges returns up to limit changes, the latest first.
+	ListRateLimitChanges(ctx context.Context, limit int) ([]*RateLimitChange, error)
+}
+
+// RateLimitChange records who replaced the rate limits, when, and with what.
+type RateLimitChange struct {
+	ID        int               `json:"id"`
+	ChangedAt time.Time         `json:"changedAt"`
+	Actor     string            `json:"actor"`
+	Previous  shared.RateLimits `json:"previous"`
+	Limits    shared.RateLimits `json:"limits"`
+}
+
+// TaskFilter selects tasks for TaskStore.ListTasks. Zero fields match every
+// task, except Limit, which must be positive.
+type TaskFilter struct {
+	// AfterID only matches tasks with a greater ID.
+	AfterID int
+	State   string
+	Type    *int
+	// CreatedAfter is inclusive, CreatedBefore exclusive.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Limit         int
+}
+
+// matches reports whether the task passes every filter but Limit.
+func (f TaskFilter) matches(task *Task) bool {
+	switch {
+	case task.ID <= f.AfterID:
+		return false
+	case f.State != "" && task.State != f.State:
+		return false
+	case f.Type != nil && task.Type != *f.Type:
+		return false
+	case !f.CreatedAfter.IsZero() && task.CreatedAt.Before(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && !task.CreatedAt.Before(f.CreatedBefore):
+		return false
+	}
+	return true
+}
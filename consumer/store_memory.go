@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryTaskStore keeps tasks in memory. It is meant for tests.
+type memoryTaskStore struct {
+	mu      sync.Mutex
+	tasks   map[int]Task
+	nextID  int
+	changes []RateLimitChange
+}
+
+func newMemoryTaskStore() *memoryTaskStore {
+	return &memoryTaskStore{tasks: make(map[int]Task), nextID: 1}
+}
+
+func (m *memoryTaskStore) CreateTask(ctx context.Context, task *Task) error {
+	return m.CreateTasks(ctx, []*Task{task})
+}
+
+func (m *memoryTaskStore) CreateTasks(_ context.Context, tasks []*Task) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, task := range tasks {
+		task.ID = m.nextID
+		m.nextID++
+		m.tasks[task.ID] = *task
+	}
+	return nil
+}
+
+func (m *memoryTaskStore) GetTask(_ context.Context, id int) (*Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	task, ok := m.tasks[id]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	return &task, nil
+}
+
+func (m *memoryTaskStore) UpdateTaskState(_ context.Context, id int, state string, updatedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Like an UPDATE matching no rows, an unknown ID is not an error.
+	if task, ok := m.tasks[id]; ok {
+		task.State = state
+		task.UpdatedAt = updatedAt
+		task.Version++
+		m.tasks[id] = task
+	}
+	return nil
+}
+
+func (m *memoryTaskStore) RecordTaskResult(_ context.Context, done *Task) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if task, ok := m.tasks[done.ID]; ok {
+		task.State = done.State
+		task.UpdatedAt = done.UpdatedAt
+		task.Result = done.Result
+		task.Version++
+		m.tasks[done.ID] = task
+	}
+	return nil
+}
+
+func (m *memoryTaskStore) RecordTaskFailure(_ context.Context, failed *Task) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if task, ok := m.tasks[failed.ID]; ok {
+		task.State = failed.State
+		task.UpdatedAt = failed.UpdatedAt
+		task.Attempts = failed.Attempts
+		task.NextRunAt = failed.NextRunAt
+		task.LastError = failed.LastError
+		task.Version++
+		m.tasks[failed.ID] = task
+	}
+	return nil
+}
+
+func (m *memoryTaskStore) ListDueTasks(_ context.Context, now time.Time, limit int) ([]*Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tasks []*Task
+	for _, task := range m.tasks {
+		if task.State == stateRetrying && !task.NextRunAt.After(now) {
+			tasks = append(tasks, &task)
+		}
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].NextRunAt.Equal(tasks[j].NextRunAt) {
+			return tasks[i].NextRunAt.Before(tasks[j].NextRunAt)
+		}
+		return tasks[i].ID < tasks[j].ID
+	})
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+	}
+	return tasks, nil
+}
+
+func (m *memoryTaskStore) RequeueTask(_ context.Context, id int, updatedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	task, ok := m.tasks[id]
+	if !ok || task.State != stateDead {
+		return ErrTaskNotFound
+	}
+	task.State = stateRetrying
+	task.UpdatedAt = updatedAt
+	task.Attempts = 0
+	task.NextRunAt = updatedAt
+	task.Version++
+	m.tasks[id] = task
+	return nil
+}
+
+func (m *memoryTaskStore) ListTasks(_ context.Context, filter TaskFilter) ([]*Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tasks []*Task
+	for _, task := range m.tasks {
+		if filter.matches(&task) {
+			tasks = append(tasks, &task)
+		}
+	}
+
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	if len(tasks) > filter.Limit {
+		tasks = tasks[:filter.Limit]
+	}
+	return tasks, nil
+}
+
+func (m *memoryTaskStore) CountTasksByState(_ context.Context) (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, task := range m.tasks {
+		counts[task.State]++
+	}
+	return counts, nil
+}
+
+func (m *memoryTaskStore) RecordRateLimitChange(_ context.Context, change *RateLimitChange) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	change.ID = len(m.changes) + 1
+	m.changes = append(m.changes, *change)
+	return nil
+}
+
+func (m *memoryTaskStore) ListRateLimitChanges(_ context.Context, limit int) ([]*RateLimitChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var changes []*RateLimitChange
+	for i := len(m.changes) - 1; i >= 0 && len(changes) < limit; i-- {
+		change := m.changes[i]
+		changes = append(changes, &change)
+	}
+	return changes, nil
+}
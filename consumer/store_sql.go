@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	taskdb "golang-assessment/shared/db"
+)
+
+// sqlTaskStore keeps tasks in the SQLite or PostgreSQL database behind a
+// storage, using the sqlc generated queries.
+type sqlTaskStore struct {
+	storage *storage
+	queries taskdb.Querier
+}
+
+func newSQLTaskStore(st *storage) *sqlTaskStore {
+	return &sqlTaskStore{storage: st, queries: st.queries(st.db)}
+}
+
+func (s *sqlTaskStore) CreateTask(ctx context.Context, task *Task) error {
+	id, err := s.queries.CreateTask(ctx, createTaskParams(task))
+	if err != nil {
+		return err
+	}
+
+	task.ID = int(id)
+	return nil
+}
+
+func (s *sqlTaskStore) CreateTasks(ctx context.Context, tasks []*Task) error {
+	tx, err := s.storage.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	q := s.storage.queries(tx)
+	ids := make([]int32, len(tasks))
+	for i, task := range tasks {
+		ids[i], err = q.CreateTask(ctx, createTaskParams(task))
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for i, task := range tasks {
+		task.ID = int(ids[i])
+	}
+	return nil
+}
+
+func createTaskParams(task *Task) taskdb.CreateTaskParams {
+	return taskdb.CreateTaskParams{
+		Type:           int32(task.Type),
+		Value:          int32(task.Value),
+		State:          task.State,
+		CreationTime:   task.CreatedAt,
+		LastUpdateTime: task.UpdatedAt,
+	}
+}
+
+func (s *sqlTaskStore) GetTask(ctx context.Context, id int) (*Task, error) {
+	row, err := s.queries.GetTaskById(ctx, int32(id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return taskFromRow(row), nil
+}
+
+func (s *sqlTaskStore) UpdateTaskState(ctx context.Context, id int, state string, updatedAt time.Time) error {
+	return s.queries.UpdateTaskState(ctx, taskdb.UpdateTaskStateParams{
+		State:          state,
+		LastUpdateTime: updatedAt,
+		ID:             int32(id),
+	})
+}
+
+func (s *sqlTaskStore) RecordTaskResult(ctx context.Context, task *Task) error {
+	return s.queries.RecordTaskResult(ctx, taskdb.RecordTaskResultParams{
+		State:          task.State,
+		LastUpdateTime: task.UpdatedAt,
+		Result:         sql.NullString{String: task.Result, Valid: true},
+		ID:             int32(task.ID),
+	})
+}
+
+func (s *sqlTaskStore) RecordTaskFailure(ctx context.Context, task *Task) error {
+	params := taskdb.RecordTaskFailureParams{
+		State:          task.State,
+		LastUpdateTime: task.UpdatedAt,
+		Attempts:       int32(task.Attempts),
+		LastError:      sql.NullString{String: task.LastError, Valid: task.LastError != ""},
+		ID:             int32(task.ID),
+	}
+	if !task.NextRunAt.IsZero() {
+		params.NextRunAt = sql.NullTime{Time: task.NextRunAt.UTC(), Valid: true}
+	}
+	return s.queries.RecordTaskFailure(ctx, params)
+}
+
+func (s *sqlTaskStore) ListDueTasks(ctx context.Context, now time.Time, limit int) ([]*Task, error) {
+	rows, err := s.queries.ListDueTasks(ctx, taskdb.ListDueTasksParams{
+		NextRunAt: sql.NullTime{Time: now.UTC(), Valid: true},
+		PageLimit: int32(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tasksFromRows(rows), nil
+}
+
+func (s *sqlTaskStore) RequeueTask(ctx context.Context, id int, updatedAt time.Time) error {
+	n, err := s.queries.RequeueDeadTask(ctx, taskdb.RequeueDeadTaskParams{
+		LastUpdateTime: updatedAt,
+		NextRunAt:      sql.NullTime{Time: updatedAt.UTC(), Valid: true},
+		ID:             int32(id),
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTaskNotFound
+	}
+	return nil
+}
+
+func (s *sqlTaskStore) ListTasks(ctx context.Context, filter TaskFilter) ([]*Task, error) {
+	params := taskdb.ListTasksParams{
+		AfterID:   int32(filter.AfterID),
+		PageLimit: int32(filter.Limit),
+	}
+	if filter.State != "" {
+		params.State = sql.NullString{String: filter.State, Valid: true}
+	}
+	if filter.Type != nil {
+		params.Type = sql.NullInt32{Int32: int32(*filter.Type), Valid: true}
+	}
+	if !filter.CreatedAfter.IsZero() {
+		params.CreatedAfter = sql.NullTime{Time: filter.CreatedAfter.UTC(), Valid: true}
+	}
+	if !filter.CreatedBefore.IsZero() {
+		params.CreatedBefore = sql.NullTime{Time: filter.CreatedBefore.UTC(), Valid: true}
+	}
+
+	rows, err := s.queries.ListTasks(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return tasksFromRows(rows), nil
+}
+
+func (s *sqlTaskStore) CountTasksByState(ctx context.Context) (map[string]int, error) {
+	rows, err := s.queries.CountTasksByState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.State] = int(row.Count)
+	}
+	return counts, nil
+}
+
+func (s *sqlTaskStore) RecordRateLimitChange(ctx context.Context, change *RateLimitChange) error {
+	previous, err := json.Marshal(change.Previous)
+	if err != nil {
+		return err
+	}
+	limits, err := json.Marshal(change.Limits)
+	if err != nil {
+		return err
+	}
+
+	id, err := s.queries.CreateRateLimitChange(ctx, taskdb.CreateRateLimitChangeParams{
+		ChangedAt: change.ChangedAt,
+		Actor:     change.Actor,
+		Previous:  string(previous),
+		Limits:    string(limits),
+	})
+	if err != nil {
+		return err
+	}
+
+	change.ID = int(id)
+	return nil
+}
+
+func (s *sqlTaskStore) ListRateLimitChanges(ctx context.Context, limit int) ([]*RateLimitChange, error) {
+	rows, err := s.queries.ListRateLimitChanges(ctx, int32(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]*RateLimitChange, len(rows))
+	for i, row := range rows {
+		change := &RateLimitChange{ID: int(row.ID), ChangedAt: row.ChangedAt, Actor: row.Actor}
+		if err := json.Unmarshal([]byte(row.Previous), &change.Previous); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(row.Limits), &change.Limits); err != nil {
+			return nil, err
+		}
+		changes[i] = change
+	}
+	return changes, nil
+}
+
+func taskFromRow(row taskdb.Task) *Task {
+	return &Task{
+		ID:        int(row.ID),
+		Type:      int(row.Type),
+		Value:     int(row.Value),
+		State:     row.State,
+		CreatedAt: row.CreationTime,
+		UpdatedAt: row.LastUpdateTime,
+		Comment:   row.Comment.String,
+		Attempts:  int(row.Attempts),
+		NextRunAt: row.NextRunAt.Time,
+		LastError: row.LastError.String,
+		Result:    row.Result.String,
+		Version:   int(row.Version),
+	}
+}
+
+func tasksFromRows(rows []taskdb.Task) []*Task {
+	tasks := make([]*Task, len(rows))
+	for i, row := range rows {
+		tasks[i] = taskFromRow(row)
+	}
+	return tasks
+}
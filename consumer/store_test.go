@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang-assessment/shared"
+)
+
+func TestMemoryTaskStore(t *testing.T) {
+	store := newMemoryTaskStore()
+	testTaskStore(t, store)
+	testRateLimitStore(t, store)
+}
+
+func TestSQLTaskStore(t *testing.T) {
+	store := newSQLTaskStore(newTestStorage(t))
+	testTaskStore(t, store)
+	testRateLimitStore(t, store)
+}
+
+func TestSQLTaskStoreConcurrentWritesToFile(t *testing.T) {
+	st, err := openStorage("sqlite://" + filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer st.Close()
+	if err := runMigrations(st); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	store := newSQLTaskStore(st)
+	ctx := context.Background()
+
+	const writers, writes = 24, 50
+	errs := make(chan error, writers*writes*2)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				now := time.Now().UTC()
+				task := &Task{Type: 1, Value: j, State: stateReceived, CreatedAt: now, UpdatedAt: now}
+				if err := store.CreateTask(ctx, task); err != nil {
+					errs <- err
+					continue
+				}
+				if err := store.UpdateTaskState(ctx, task.ID, stateProcessing, now); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	failed := 0
+	for err := range errs {
+		if failed == 0 {
+			t.Errorf("Error writing concurrently: %v", err)
+		}
+		failed++
+	}
+	if failed > 0 {
+		t.Fatalf("%d of %d concurrent writes failed", failed, writers*writes*2)
+	}
+
+	counts, err := store.CountTasksByState(ctx)
+	if err != nil {
+		t.Fatalf("Error counting tasks: %v", err)
+	}
+	if counts[stateProcessing] != writers*writes {
+		t.Errorf("Expected %d processing tasks, got %v", writers*writes, counts)
+	}
+}
+
+// testTaskStore checks the behaviour every TaskStore must share. It tolerates
+// tasks already in the store, so it can run against a shared database.
+func testTaskStore(t *testing.T, store TaskStore) {
+	ctx := context.Background()
+
+	before, err := store.CountTasksByState(ctx)
+	if err != nil {
+		t.Fatalf("Error counting tasks: %v", err)
+	}
+
+	created := time.Now().UTC().Truncate(time.Millisecond)
+	first := &Task{Type: 1, Value: 10, State: stateReceived, CreatedAt: created, UpdatedAt: created}
+	if err := store.CreateTask(ctx, first); err != nil {
+		t.Fatalf("Error creating task: %v", err)
+	}
+	if first.ID == 0 {
+		t.Fatalf("Expected task ID to be set after creating")
+	}
+
+	batch := []*Task{
+		{Type: 2, Value: 20, State: stateReceived, CreatedAt: created.Add(time.Second), UpdatedAt: created},
+		{Type: 1, Value: 30, State: stateReceived, CreatedAt: created.Add(2 * time.Second), UpdatedAt: created},
+	}
+	if err := store.CreateTasks(ctx, batch); err != nil {
+		t.Fatalf("Error creating tasks: %v", err)
+	}
+	if batch[0].ID <= first.ID || batch[1].ID <= batch[0].ID {
+		t.Fatalf("Expected increasing IDs, got %d, %d, %d", first.ID, batch[0].ID, batch[1].ID)
+	}
+
+	updated := created.Add(time.Minute)
+	if err := store.UpdateTaskState(ctx, first.ID, stateProcessing, updated); err != nil {
+		t.Fatalf("Error updating task state: %v", err)
+	}
+	done := *first
+	done.State = stateDone
+	done.UpdatedAt = updated
+	done.Result = "42"
+	if err := store.RecordTaskResult(ctx, &done); err != nil {
+		t.Fatalf("Error recording task result: %v", err)
+	}
+
+	got, err := store.GetTask(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("Error getting task: %v", err)
+	}
+	if got.Type != 1 || got.Value != 10 || got.State != stateDone || got.Result != "42" {
+		t.Errorf("Unexpected task: %+v", got)
+	}
+	if got.Version != 2 {
+		t.Errorf("Expected two state changes to bring the task to version 2, got %d", got.Version)
+	}
+	if !got.CreatedAt.Equal(created) || !got.UpdatedAt.Equal(updated) {
+		t.Errorf("Expected timestamps %v and %v, got %v and %v", created, updated, got.CreatedAt, got.UpdatedAt)
+	}
+
+	if _, err := store.GetTask(ctx, batch[1].ID+1000); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Expected ErrTaskNotFound, got %v", err)
+	}
+
+	taskType := 1
+	tests := []struct {
+		name   string
+		filter TaskFilter
+		want   []int
+	}{
+		{"all", TaskFilter{}, []int{first.ID, batch[0].ID, batch[1].ID}},
+		{"limit", TaskFilter{Limit: 2}, []int{first.ID, batch[0].ID}},
+		{"after", TaskFilter{AfterID: batch[0].ID}, []int{batch[1].ID}},
+		{"state", TaskFilter{State: stateReceived}, []int{batch[0].ID, batch[1].ID}},
+		{"type", TaskFilter{Type: &taskType}, []int{first.ID, batch[1].ID}},
+		{"created after", TaskFilter{CreatedAfter: created.Add(time.Second)}, []int{batch[0].ID, batch[1].ID}},
+		{"created before", TaskFilter{CreatedBefore: created.Add(time.Second)}, []int{first.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := tt.filter
+			if filter.AfterID == 0 {
+				filter.AfterID = first.ID - 1
+			}
+			if filter.Limit == 0 {
+				filter.Limit = 100
+			}
+
+			tasks, err := store.ListTasks(ctx, filter)
+			if err != nil {
+				t.Fatalf("Error listing tasks: %v", err)
+			}
+
+			var ids []int
+			for _, task := range tasks {
+				ids = append(ids, task.ID)
+			}
+			if len(ids) != len(tt.want) {
+				t.Fatalf("Expected tasks %v, got %v", tt.want, ids)
+			}
+			for i := range ids {
+				if ids[i] != tt.want[i] {
+					t.Fatalf("Expected tasks %v, got %v", tt.want, ids)
+				}
+			}
+		})
+	}
+
+	after, err := store.CountTasksByState(ctx)
+	if err != nil {
+		t.Fatalf("Error counting tasks: %v", err)
+	}
+	if n := after[stateReceived] - before[stateReceived]; n != 2 {
+		t.Errorf("Expected 2 more received tasks, got %d", n)
+	}
+	if n := after[stateDone] - before[stateDone]; n != 1 {
+		t.Errorf("Expected 1 more done task, got %d", n)
+	}
+
+	retryAt := created.Add(time.Hour)
+	retrying := *batch[0]
+	retrying.State = stateRetrying
+	retrying.UpdatedAt = updated
+	retrying.Attempts = 1
+	retrying.NextRunAt = retryAt
+	retrying.LastError = "boom"
+	if err := store.RecordTaskFailure(ctx, &retrying); err != nil {
+		t.Fatalf("Error recording task failure: %v", err)
+	}
+
+	got, err = store.GetTask(ctx, retrying.ID)
+	if err != nil {
+		t.Fatalf("Error getting task: %v", err)
+	}
+	if got.State != stateRetrying || got.Attempts != 1 || got.LastError != "boom" || !got.NextRunAt.Equal(retryAt) {
+		t.Errorf("Unexpected retrying task: %+v", got)
+	}
+
+	if due := dueTaskIDs(t, store, retryAt.Add(-time.Second)); due[retrying.ID] {
+		t.Errorf("Expected task %d not to be due before %v", retrying.ID, retryAt)
+	}
+	if due := dueTaskIDs(t, store, retryAt); !due[retrying.ID] {
+		t.Errorf("Expected task %d to be due at %v", retrying.ID, retryAt)
+	}
+
+	dead := *batch[1]
+	dead.State = stateDead
+	dead.UpdatedAt = updated
+	dead.Attempts = 3
+	dead.LastError = "boom"
+	if err := store.RecordTaskFailure(ctx, &dead); err != nil {
+		t.Fatalf("Error recording task failure: %v", err)
+	}
+
+	if err := store.RequeueTask(ctx, retrying.ID, updated); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Expected ErrTaskNotFound requeueing a retrying task, got %v", err)
+	}
+	if err := store.RequeueTask(ctx, dead.ID, retryAt); err != nil {
+		t.Fatalf("Error requeueing task: %v", err)
+	}
+
+	got, err = store.GetTask(ctx, dead.ID)
+	if err != nil {
+		t.Fatalf("Error getting task: %v", err)
+	}
+	if got.State != stateRetrying || got.Attempts != 0 || !got.NextRunAt.Equal(retryAt) {
+		t.Errorf("Unexpected requeued task: %+v", got)
+	}
+}
+
+// dueTaskIDs returns the IDs of the tasks ListDueTasks reports due at now.
+func dueTaskIDs(t *testing.T, store TaskStore, now time.Time) map[int]bool {
+	t.Helper()
+
+	tasks, err := store.ListDueTasks(context.Background(), now, 1000)
+	if err != nil {
+		t.Fatalf("Error listing due tasks: %v", err)
+	}
+
+	ids := make(map[int]bool, len(tasks))
+	for _, task := range tasks {
+		if task.State != stateRetrying {
+			t.Errorf("Expected only retrying tasks, got %+v", task)
+		}
+		ids[task.ID] = true
+	}
+	return ids
+}
+
+// testRateLimitStore checks the behaviour every RateLimitStore must share.
+func testRateLimitStore(t *testing.T, store RateLimitStore) {
+	ctx := context.Background()
+
+	changedAt := time.Now().UTC().Truncate(time.Millisecond)
+	first := &RateLimitChange{
+		ChangedAt: changedAt,
+		Actor:     "alice",
+		Limits:    shared.RateLimits{PerClient: shared.RateLimit{Rate: 5, Burst: 20}},
+	}
+	second := &RateLimitChange{
+		ChangedAt: changedAt.Add(time.Minute),
+		Actor:     "bob",
+		Previous:  first.Limits,
+		Limits: shared.RateLimits{
+			PerClient: shared.RateLimit{Rate: 5, Burst: 20},
+			Types:     map[string]shared.RateLimit{"3": {Rate: 1, Burst: 2}},
+		},
+	}
+	for _, change := range []*RateLimitChange{first, second} {
+		if err := store.RecordRateLimitChange(ctx, change); err != nil {
+			t.Fatalf("Error recording rate limit change: %v", err)
+		}
+	}
+	if second.ID <= first.ID {
+		t.Fatalf("Expected increasing IDs, got %d, %d", first.ID, second.ID)
+	}
+
+	changes, err := store.ListRateLimitChanges(ctx, 10)
+	if err != nil {
+		t.Fatalf("Error listing rate limit changes: %v", err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %d", len(changes))
+	}
+	got := changes[0]
+	if got.ID != second.ID || got.Actor != "bob" || !got.ChangedAt.Equal(second.ChangedAt) {
+		t.Errorf("Expected the latest change first, got %+v", got)
+	}
+	if !reflect.DeepEqual(got.Limits, second.Limits) || !reflect.DeepEqual(got.Previous, second.Previous) {
+		t.Errorf("Expected limits %+v after %+v, got %+v after %+v", second.Limits, second.Previous, got.Limits, got.Previous)
+	}
+
+	latest, err := store.ListRateLimitChanges(ctx, 1)
+	if err != nil {
+		t.Fatalf("Error listing rate limit changes: %v", err)
+	}
+	if len(latest) != 1 || latest[0].ID != second.ID {
+		t.Errorf("Expected only change %d, got %v", second.ID, latest)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer returns the tracer for the consumer's spans from whatever tracer
+// provider is installed globally when the span starts, which is a no-op
+// until main sets one up.
+func tracer() trace.Tracer {
+	return otel.Tracer("golang-assessment/consumer")
+}
+
+func taskAttributes(task *Task) trace.SpanStartEventOption {
+	return trace.WithAttributes(
+		attribute.Int("task.id", task.ID),
+		attribute.Int("task.type", task.Type),
+		attribute.Int("task.value", task.Value),
+	)
+}
+
+// endSpan records err, if any, on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
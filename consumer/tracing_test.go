@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"golang-assessment/golang-assessment/proto"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider recording every span for the rest
+// of the test.
+func recordSpans(t *testing.T) (*tracetest.SpanRecorder, trace.Tracer) {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	return recorder, provider.Tracer("test")
+}
+
+func TestSendTaskContinuesTrace(t *testing.T) {
+	recorder, testTracer := recordSpans(t)
+
+	s, _ := newTestServer(t)
+	client := startTestGRPCServer(t, s)
+
+	ctx, parent := testTracer.Start(context.Background(), "produceTask")
+	_, err := client.SendTask(ctx, &proto.TaskRequest{Type: 2, Value: 1})
+	parent.End()
+	if err != nil {
+		t.Fatalf("Error in SendTask: %v", err)
+	}
+
+	s.processTask(context.Background(), <-s.queue)
+
+	traceID := parent.SpanContext().TraceID()
+	seen := make(map[string]bool)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() != traceID {
+			t.Errorf("Span %q is not part of the producer's trace", span.Name())
+		}
+		seen[span.Name()] = true
+	}
+	for _, name := range []string{"task.TaskService/SendTask", "rateLimit", "SaveTask", "processTask", "handler"} {
+		if !seen[name] {
+			t.Errorf("Expected a %q span, got %v", name, seen)
+		}
+	}
+}
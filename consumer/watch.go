@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	"golang-assessment/golang-assessment/proto"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// watchBufferSize is how many events a watcher may fall behind by before it
+// is disconnected.
+const watchBufferSize = 256
+
+// taskBroker fans task state transitions out to every active watcher.
+type taskBroker struct {
+	mu     sync.Mutex
+	subs   map[chan *proto.TaskEvent]struct{}
+	closed bool
+}
+
+func newTaskBroker() *taskBroker {
+	return &taskBroker{subs: make(map[chan *proto.TaskEvent]struct{})}
+}
+
+// subscribe registers a watcher. The returned channel is closed when the
+// watcher falls behind, when the broker is closed or after the returned
+// cancel function is called.
+func (b *taskBroker) subscribe() (<-chan *proto.TaskEvent, func()) {
+	ch := make(chan *proto.TaskEvent, watchBufferSize)
+
+	b.mu.Lock()
+	if b.closed {
+		close(ch)
+	} else {
+		b.subs[ch] = struct{}{}
+	}
+	b.mu.Unlock()
+
+	return ch, func() { b.unsubscribe(ch) }
+}
+
+// close ends every watch, so that the server can stop.
+func (b *taskBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// endedError tells a watcher whose channel was closed why it was dropped.
+func (b *taskBroker) endedError() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return errDraining
+	}
+	return status.Error(codes.ResourceExhausted, "watcher fell behind")
+}
+
+func (b *taskBroker) unsubscribe(ch chan *proto.TaskEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// publish delivers the event to every watcher without blocking. Watchers
+// whose buffer is full are dropped rather than silently missing events.
+func (b *taskBroker) publish(event *proto.TaskEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// publishTransition notifies watchers that the task moved out of previous
+// into its current state.
+func (s *TaskServiceServer) publishTransition(task *Task, previous string) {
+	s.events.publish(&proto.TaskEvent{
+		Task:          task.toProto(),
+		PreviousState: previous,
+	})
+}
+
+func isTerminalState(state string) bool {
+	return state == stateDone || state == stateFailed || state == stateDead
+}
+
+// WatchTask streams the current state of a task followed by each of its
+// transitions, and ends once the task reaches a terminal state.
+func (s *TaskServiceServer) WatchTask(req *proto.WatchTaskRequest, stream grpc.ServerStreamingServer[proto.TaskEvent]) error {
+	ctx := stream.Context()
+
+	// Subscribe before reading the snapshot so no transition is missed.
+	events, cancel := s.events.subscribe()
+	defer cancel()
+
+	snapshot, err := s.store.GetTask(ctx, int(req.Id))
+	if errors.Is(err, ErrTaskNotFound) {
+		return status.Errorf(codes.NotFound, "task %d not found", req.Id)
+	}
+	if err != nil {
+		logrus.Error("Failed to get task: ", err)
+		return status.Errorf(codes.Internal, "failed to get task: %v", err)
+	}
+
+	if err := stream.Send(&proto.TaskEvent{Task: snapshot.toProto()}); err != nil {
+		return err
+	}
+	if isTerminalState(snapshot.State) {
+		return nil
+	}
+
+	// Transitions published before the snapshot was read are already in it.
+	// Every state change increments the version, so skip those up to the
+	// snapshot's.
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-events:
+			if !ok {
+				return s.events.endedError()
+			}
+			if event.Task.Id != req.Id || int(event.Task.Version) <= snapshot.Version {
+				continue
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+			if isTerminalState(event.Task.State) {
+				return nil
+			}
+		}
+	}
+}
+
+// WatchTasks streams every transition matching the request filters until the
+// client goes away.
+func (s *TaskServiceServer) WatchTasks(req *proto.WatchTasksRequest, stream grpc.ServerStreamingServer[proto.TaskEvent]) error {
+	ctx := stream.Context()
+
+	events, cancel := s.events.subscribe()
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-events:
+			if !ok {
+				return s.events.endedError()
+			}
+			if req.State != "" && event.Task.State != req.State {
+				continue
+			}
+			if req.Type != nil && event.Task.Type != req.GetType() {
+				continue
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StartWorkers launches n goroutines that process queued tasks until ctx is
+// cancelled or Shutdown stops them. At least one worker is always started.
+func (s *TaskServiceServer) StartWorkers(ctx context.Context, n int) {
+	if n < 1 {
+		n = 1
+	}
+	ctx, s.stopWorkers = context.WithCancel(ctx)
+	for i := 0; i < n; i++ {
+		s.wg.Add(1)
+		go s.worker(ctx)
+	}
+	s.wg.Add(1)
+	go s.scheduleRetries(ctx)
+	logrus.Infof("Started %d task workers", n)
+}
+
+// Wait blocks until every worker has returned.
+func (s *TaskServiceServer) Wait() {
+	s.wg.Wait()
+}
+
+// reserveQueue claims room in the queue for n tasks, and reports false,
+// claiming nothing, when there is not enough. Every task is enqueued in room
+// claimed this way, which the worker that takes it frees again.
+func (s *TaskServiceServer) reserveQueue(n int) bool {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+
+	if s.queued+n > cap(s.queue) {
+		return false
+	}
+	s.queued += n
+	return true
+}
+
+// releaseQueue frees n slots of the queue, claimed for tasks that were not
+// enqueued after all or taken out by a worker.
+func (s *TaskServiceServer) releaseQueue(n int) {
+	s.queueMu.Lock()
+	s.queued -= n
+	s.queueMu.Unlock()
+
+	select {
+	case s.dequeued <- struct{}{}:
+	default:
+	}
+}
+
+// queueRoom returns how many more tasks the queue has room for.
+func (s *TaskServiceServer) queueRoom() int {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+	return cap(s.queue) - s.queued
+}
+
+// enqueue hands the task to the worker pool, in room claimed with
+// reserveQueue, so it never blocks.
+func (s *TaskServiceServer) enqueue(task *Task) {
+	s.queue <- task
+}
+
+// enqueueWhenRoom waits until the queue has room for the task and enqueues
+// it. It reports false, leaving the task out, if the server starts stopping
+// or ctx is cancelled first.
+func (s *TaskServiceServer) enqueueWhenRoom(ctx context.Context, task *Task) bool {
+	for !s.reserveQueue(1) {
+		select {
+		case <-s.dequeued:
+		case <-s.stopping:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+	s.enqueue(task)
+	return true
+}
+
+// requeue puts a task that could not be started back on the queue after
+// retryPollInterval, in the background. The task stays received meanwhile,
+// and until the next start if the server stops first.
+func (s *TaskServiceServer) requeue(ctx context.Context, task *Task) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		timer := time.NewTimer(retryPollInterval)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-s.stopping:
+			return
+		case <-ctx.Done():
+			return
+		}
+		s.enqueueWhenRoom(ctx, task)
+	}()
+}
+
+func (s *TaskServiceServer) worker(ctx context.Context) {
+	defer s.wg.Done()
+
+	for {
+		task, ok := s.next(ctx)
+		if !ok {
+			return
+		}
+		s.processTask(ctx, task)
+	}
+}
+
+// processTask moves a task through processing to done. A task that cannot be
+// marked as processing goes back on the queue. A failing handler schedules a
+// retry or moves the task to dead, and a state that cannot be recorded
+// afterwards leaves the task failed. Work interrupted by the worker stopping
+// goes back to received, to be resumed on the next start.
+func (s *TaskServiceServer) processTask(ctx context.Context, task *Task) {
+	// Continue the trace of the request that admitted the task, if any.
+	ctx, span := tracer().Start(trace.ContextWithSpanContext(ctx, task.spanContext), "processTask", taskAttributes(task))
+	defer func() {
+		span.SetAttributes(attribute.String("task.state", task.State))
+		span.End()
+	}()
+
+	if err := s.updateTaskState(ctx, task, stateProcessing); err != nil {
+		logrus.Errorf("Failed to mark task %d as processing, queueing it again: %v", task.ID, err)
+		s.requeue(ctx, task)
+		return
+	}
+
+	result, err := s.runHandler(ctx, task)
+	if ctx.Err() != nil {
+		// The worker context is gone, so record the state on a fresh one.
+		if err := s.updateTaskState(context.Background(), task, stateReceived); err != nil {
+			logrus.Errorf("Failed to mark interrupted task %d as received: %v", task.ID, err)
+		}
+		return
+	}
+	if err != nil {
+		if err := s.recordFailure(ctx, task, err); err != nil {
+			logrus.Errorf("Failed to record failure of task %d: %v", task.ID, err)
+		}
+		return
+	}
+
+	if err := s.completeTask(ctx, task, result); err != nil {
+		logrus.Errorf("Failed to mark task %d as done: %v", task.ID, err)
+		if err := s.updateTaskState(ctx, task, stateFailed); err != nil {
+			logrus.Errorf("Failed to mark task %d as failed: %v", task.ID, err)
+		}
+		return
+	}
+
+	s.metrics.tasksProcessed.With(prometheus.Labels{"type": typeLabel(task)}).(prometheus.ExemplarAdder).AddWithExemplar(1, taskExemplar(task))
+	s.metrics.tasksProcessedValue.With(prometheus.Labels{"type": typeLabel(task)}).Add(float64(task.Value))
+
+	logrus.Infof("Task processed: %+v", task)
+}
+
+// runHandler runs the handler registered for the type of the task. Tasks are
+// checked on admission, so a missing handler fails the attempt like any
+// other handler error.
+func (s *TaskServiceServer) runHandler(ctx context.Context, task *Task) (string, error) {
+	handler, ok := s.handlers.Lookup(task.Type)
+	if !ok {
+		return "", fmt.Errorf("no handler for task type %d", task.Type)
+	}
+
+	ctx, span := tracer().Start(ctx, "handler", taskAttributes(task))
+	start := time.Now()
+	result, err := handler.Handle(ctx, task)
+	endSpan(span, err)
+	observeTask(s.metrics.handlerDuration.WithLabelValues(typeLabel(task)), time.Since(start).Seconds(), task)
+	return result, err
+}
+
+// completeTask moves the task to done and stores the result of its handler.
+func (s *TaskServiceServer) completeTask(ctx context.Context, task *Task, result string) error {
+	done := *task
+	done.State = stateDone
+	done.UpdatedAt = time.Now().UTC()
+	done.Result = result
+	done.Version++
+	if err := s.store.RecordTaskResult(ctx, &done); err != nil {
+		return err
+	}
+
+	previous := task.State
+	*task = done
+	s.metrics.trackTaskState(previous, task.State)
+	observeTask(s.metrics.endToEnd.WithLabelValues(typeLabel(task)), task.UpdatedAt.Sub(task.CreatedAt).Seconds(), task)
+	s.publishTransition(task, previous)
+	return nil
+}
+
+// updateTaskState persists the new state of the task, records it in the task
+// state metric and notifies watchers.
+func (s *TaskServiceServer) updateTaskState(ctx context.Context, task *Task, state string) error {
+	now := time.Now().UTC()
+	if err := s.store.UpdateTaskState(ctx, task.ID, state, now); err != nil {
+		return err
+	}
+
+	previous := task.State
+	task.State = state
+	task.UpdatedAt = now
+	task.Version++
+	s.metrics.trackTaskState(previous, state)
+	s.publishTransition(task, previous)
+	return nil
+}
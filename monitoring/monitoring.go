@@ -0,0 +1,212 @@
+// Package monitoring loads the PromQL expressions of the Prometheus rules and
+// Grafana dashboards shipped in this directory, so that tests can check them
+// against the metrics the services export.
+package monitoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// Paths of the rule file and the dashboards, relative to this directory.
+const (
+	RulesFile     = "rules.yml"
+	DashboardsDir = "grafana/dashboards"
+)
+
+// scrapeSeries are written by Prometheus itself for every scrape target.
+var scrapeSeries = map[string]bool{
+	"up":                                    true,
+	"scrape_duration_seconds":               true,
+	"scrape_samples_scraped":                true,
+	"scrape_series_added":                   true,
+	"scrape_samples_post_metric_relabeling": true,
+}
+
+// grafanaVariables stand in for the interval variables Grafana substitutes
+// before it sends a query, so that dashboard queries parse.
+var grafanaVariables = strings.NewReplacer(
+	"$__rate_interval", "5m",
+	"$__interval", "1m",
+	"$__range", "1h",
+)
+
+// Expression is a PromQL expression and where it was found.
+type Expression struct {
+	Source string
+	Expr   string
+}
+
+// Selector is a series selector in an expression.
+type Selector struct {
+	Source string
+	Metric string
+	// Job is the value the selector requires of the job label, or empty.
+	Job string
+}
+
+// Queries holds every expression in the rules and dashboards.
+type Queries struct {
+	Expressions []Expression
+	Selectors   []Selector
+	// Recorded holds the names of the series written by recording rules.
+	Recorded map[string]bool
+}
+
+// Load parses the rule file and dashboards under dir. It fails on a rule
+// file Prometheus would reject and on any expression that does not parse.
+func Load(dir string) (*Queries, error) {
+	q := &Queries{Recorded: make(map[string]bool)}
+
+	groups, errs := rulefmt.ParseFile(filepath.Join(dir, RulesFile))
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid rule file: %v", errs[0])
+	}
+	for _, group := range groups.Groups {
+		for _, rule := range group.Rules {
+			source := fmt.Sprintf("%s: group %s", RulesFile, group.Name)
+			if rule.Record.Value != "" {
+				q.Recorded[rule.Record.Value] = true
+				source += ": record " + rule.Record.Value
+			} else {
+				source += ": alert " + rule.Alert.Value
+			}
+			q.Expressions = append(q.Expressions, Expression{Source: source, Expr: rule.Expr.Value})
+		}
+	}
+
+	dashboards, err := filepath.Glob(filepath.Join(dir, DashboardsDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range dashboards {
+		exprs, err := dashboardExpressions(path)
+		if err != nil {
+			return nil, err
+		}
+		q.Expressions = append(q.Expressions, exprs...)
+	}
+
+	for _, e := range q.Expressions {
+		expr, err := parser.ParseExpr(grafanaVariables.Replace(e.Expr))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", e.Source, err)
+		}
+		parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+			if vs, ok := node.(*parser.VectorSelector); ok {
+				q.Selectors = append(q.Selectors, newSelector(e.Source, vs))
+			}
+			return nil
+		})
+	}
+	return q, nil
+}
+
+func newSelector(source string, vs *parser.VectorSelector) Selector {
+	s := Selector{Source: source, Metric: vs.Name}
+	for _, m := range vs.LabelMatchers {
+		if m.Type != labels.MatchEqual {
+			continue
+		}
+		switch m.Name {
+		case labels.MetricName:
+			s.Metric = m.Value
+		case "job":
+			s.Job = m.Value
+		}
+	}
+	return s
+}
+
+type dashboard struct {
+	Title  string  `json:"title"`
+	Panels []panel `json:"panels"`
+}
+
+type panel struct {
+	Title   string `json:"title"`
+	Targets []struct {
+		RefID string `json:"refId"`
+		Expr  string `json:"expr"`
+	} `json:"targets"`
+	// Panels holds the panels of a collapsed row.
+	Panels []panel `json:"panels"`
+}
+
+// dashboardExpressions returns the queries of every panel in the dashboard.
+func dashboardExpressions(path string) ([]Expression, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var d dashboard
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("invalid dashboard %s: %v", path, err)
+	}
+
+	var exprs []Expression
+	var walk func(panels []panel)
+	walk = func(panels []panel) {
+		for _, p := range panels {
+			for _, target := range p.Targets {
+				if target.Expr == "" {
+					continue
+				}
+				exprs = append(exprs, Expression{
+					Source: fmt.Sprintf("%s: panel %q: query %s", filepath.Base(path), p.Title, target.RefID),
+					Expr:   target.Expr,
+				})
+			}
+			walk(p.Panels)
+		}
+	}
+	walk(d.Panels)
+	return exprs, nil
+}
+
+// MissingForJob returns the selectors of job whose metric is not in exported,
+// leaving out the series Prometheus writes itself.
+func (q *Queries) MissingForJob(job string, exported map[string]bool) []Selector {
+	var missing []Selector
+	for _, s := range q.Selectors {
+		if s.Job == job && !exported[s.Metric] && !scrapeSeries[s.Metric] {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+// ExportedNames returns the names of the series exposed for the metrics in
+// g, including the _bucket, _sum and _count series of histograms and
+// summaries.
+func ExportedNames(g prometheus.Gatherer) (map[string]bool, error) {
+	families, err := g.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, family := range families {
+		name := family.GetName()
+		names[name] = true
+		switch family.GetType() {
+		case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+			names[name+"_bucket"] = true
+			names[name+"_sum"] = true
+			names[name+"_count"] = true
+		case dto.MetricType_SUMMARY:
+			names[name+"_sum"] = true
+			names[name+"_count"] = true
+		}
+	}
+	return names, nil
+}
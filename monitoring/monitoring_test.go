@@ -0,0 +1,105 @@
+package monitoring
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// prometheusConfig is the part of prometheus.yml the rules depend on.
+type prometheusConfig struct {
+	RuleFiles     []string `yaml:"rule_files"`
+	ScrapeConfigs []struct {
+		JobName string `yaml:"job_name"`
+	} `yaml:"scrape_configs"`
+}
+
+func loadPrometheusConfig(t *testing.T) prometheusConfig {
+	t.Helper()
+
+	data, err := os.ReadFile("../prometheus.yml")
+	if err != nil {
+		t.Fatalf("Error reading prometheus.yml: %v", err)
+	}
+	var config prometheusConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		t.Fatalf("Error parsing prometheus.yml: %v", err)
+	}
+	return config
+}
+
+func TestLoad(t *testing.T) {
+	q, err := Load(".")
+	if err != nil {
+		t.Fatalf("Error loading queries: %v", err)
+	}
+
+	var rules, panels int
+	for _, e := range q.Expressions {
+		if strings.HasPrefix(e.Source, RulesFile) {
+			rules++
+		} else {
+			panels++
+		}
+	}
+	if rules == 0 || panels == 0 {
+		t.Errorf("Expected rule and dashboard expressions, got %d and %d", rules, panels)
+	}
+	if len(q.Recorded) == 0 {
+		t.Errorf("Expected recording rules")
+	}
+}
+
+func TestPrometheusLoadsRules(t *testing.T) {
+	config := loadPrometheusConfig(t)
+
+	for _, file := range config.RuleFiles {
+		if file == RulesFile {
+			return
+		}
+	}
+	t.Errorf("Expected %s in the rule_files of prometheus.yml, got %v", RulesFile, config.RuleFiles)
+}
+
+// TestSelectorsNameAJob makes sure every selector can be checked against the
+// metrics of one service: it selects a recorded series, or the series of a
+// single scrape job.
+func TestSelectorsNameAJob(t *testing.T) {
+	q, err := Load(".")
+	if err != nil {
+		t.Fatalf("Error loading queries: %v", err)
+	}
+
+	jobs := make(map[string]bool)
+	for _, scrape := range loadPrometheusConfig(t).ScrapeConfigs {
+		jobs[scrape.JobName] = true
+	}
+
+	for _, s := range q.Selectors {
+		switch {
+		case q.Recorded[s.Metric]:
+		case strings.Contains(s.Metric, ":"):
+			t.Errorf("%s: %s is not written by any recording rule", s.Source, s.Metric)
+		case s.Job == "":
+			t.Errorf("%s: %s must select a single job", s.Source, s.Metric)
+		case !jobs[s.Job]:
+			t.Errorf("%s: %s selects job %q, which Prometheus does not scrape", s.Source, s.Metric, s.Job)
+		}
+	}
+}
+
+func TestMissingForJob(t *testing.T) {
+	q := &Queries{Selectors: []Selector{
+		{Source: "a", Metric: "tasks_processed_total", Job: "consumer"},
+		{Source: "b", Metric: "tasks_typo_total", Job: "consumer"},
+		{Source: "c", Metric: "up", Job: "consumer"},
+		{Source: "d", Metric: "tasks_produced_total", Job: "producer"},
+	}}
+
+	missing := q.MissingForJob("consumer", map[string]bool{"tasks_processed_total": true})
+	if len(missing) != 1 || missing[0].Source != "b" {
+		t.Errorf("Expected only the typo to be missing, got %v", missing)
+	}
+}
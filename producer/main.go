@@ -2,33 +2,117 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"golang-assessment/golang-assessment/proto"
 	"golang-assessment/shared"
+	"io"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 var version = "1.0.0"
 
-var taskCounter = prometheus.NewCounterVec(
-	prometheus.CounterOpts{
-		Name: "tasks_produced_total",
-		Help: "Total number of tasks produced",
-	},
-	[]string{"type"},
-)
+// tracer returns the tracer for the producer's spans from the tracer
+// provider installed globally when the span starts.
+func tracer() trace.Tracer {
+	return otel.Tracer("golang-assessment/producer")
+}
+
+// Producer generates random tasks and sends them to the consumer in batches.
+type Producer struct {
+	client    proto.TaskServiceClient
+	logger    *logrus.Logger
+	batchSize int
+	// retry sets how a batch rejected with a retryable code is sent again.
+	retry shared.RetryPolicy
+	// pause is the wait before each batch, raised while the consumer pushes
+	// back.
+	pause time.Duration
+	// outbox keeps each task until the consumer accepts it, unless nil.
+	// outboxDepth is the number of tasks pending in it when last counted.
+	outbox      *outbox
+	outboxDepth int
 
-func init() {
-	prometheus.MustRegister(taskCounter)
+	produced   *prometheus.CounterVec
+	values     *prometheus.HistogramVec
+	retries    *prometheus.CounterVec
+	dropped    *prometheus.CounterVec
+	pauseGauge prometheus.Gauge
+	outboxSize prometheus.Gauge
+}
+
+// NewProducer returns a producer sending batches of batchSize tasks through
+// client, with its metrics registered in reg.
+func NewProducer(client proto.TaskServiceClient, reg prometheus.Registerer, logger *logrus.Logger, batchSize int) *Producer {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	p := &Producer{
+		client:    client,
+		logger:    logger,
+		batchSize: batchSize,
+		retry:     defaultSendRetry,
+		produced: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "tasks_produced_total",
+				Help: "Total number of tasks produced",
+			},
+			[]string{"type"},
+		),
+		values: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "tasks_produced_value",
+				Help:    "Distribution of the values of the tasks produced, by type",
+				Buckets: prometheus.LinearBuckets(10, 10, 10),
+			},
+			[]string{"type"},
+		),
+		retries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "task_send_retries_total",
+				Help: "Number of times a batch was sent again after a retryable error, by gRPC code",
+			},
+			[]string{"code"},
+		),
+		dropped: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "tasks_dropped_total",
+				Help: "Number of tasks given up on without the consumer accepting them, by gRPC code",
+			},
+			[]string{"code"},
+		),
+		pauseGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "task_send_pause_seconds",
+			Help: "Wait before each batch, raised while the consumer pushes back",
+		}),
+		outboxSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "task_outbox_depth",
+			Help: "Number of tasks in the outbox the consumer has not accepted yet",
+		}),
+	}
+	reg.MustRegister(p.produced, p.values, p.retries, p.dropped, p.pauseGauge, p.outboxSize)
+	return p
 }
 
 func produceTask() (int, int) {
@@ -38,6 +122,129 @@ func produceTask() (int, int) {
 	return taskType, taskValue
 }
 
+// produceRequest produces a random task and records it in the produced
+// task metrics.
+func (p *Producer) produceRequest(ctx context.Context) *proto.TaskRequest {
+	_, span := tracer().Start(ctx, "produceTask")
+	defer span.End()
+
+	taskType, taskValue := produceTask()
+	span.SetAttributes(attribute.Int("task.type", taskType), attribute.Int("task.value", taskValue))
+
+	p.produced.With(prometheus.Labels{"type": strconv.Itoa(taskType)}).Inc()
+	p.values.With(prometheus.Labels{"type": strconv.Itoa(taskType)}).Observe(float64(taskValue))
+
+	return &proto.TaskRequest{
+		Type:  int32(taskType),
+		Value: int32(taskValue),
+	}
+}
+
+// sendBatch streams the batch to the consumer, which stores it in a single
+// transaction and returns one response per task, along with the trailer of
+// the stream.
+func sendBatch(ctx context.Context, client proto.TaskServiceClient, batch []*proto.TaskRequest) (*proto.SendTasksResponse, metadata.MD, error) {
+	stream, err := client.SendTasks(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, req := range batch {
+		if err := stream.Send(req); err != nil {
+			// io.EOF means the consumer aborted the stream; its status is
+			// returned by CloseAndRecv.
+			if err == io.EOF {
+				break
+			}
+			return nil, nil, err
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	return resp, stream.Trailer(), err
+}
+
+// Run first sends the tasks a previous run left in the outbox, then produces
+// total tasks and sends them in batches, pausing between them while the
+// consumer pushes back. A batch the consumer keeps rejecting is logged and
+// dropped, unless the outbox keeps it, to be replayed after the next batch
+// that gets through or by replayEvery. Once ctx is cancelled no further batch
+// is produced, but Run returns only after the batch being sent is answered.
+func (p *Producer) Run(ctx context.Context, total int) {
+	if p.outbox != nil {
+		p.replay(ctx)
+	}
+	for produced := 0; produced < total; {
+		if sleep(ctx, p.pause) != nil {
+			return
+		}
+		n := min(p.batchSize, total-produced)
+		if p.runBatch(ctx, n) && p.outboxDepth > 0 {
+			p.replay(ctx)
+		}
+		produced += n
+	}
+}
+
+// runBatch produces and sends one batch of n tasks in a trace of its own,
+// which the consumer continues, and reports whether the batch got through.
+func (p *Producer) runBatch(ctx context.Context, n int) bool {
+	ctx, span := tracer().Start(ctx, "produceBatch", trace.WithAttributes(attribute.Int("tasks.count", n)))
+	defer span.End()
+
+	batch := make([]*proto.TaskRequest, 0, n)
+	for len(batch) < n {
+		req := p.produceRequest(ctx)
+
+		p.logger.Infof("Produced task type: %d, value: %d", req.Type, req.Value)
+
+		batch = append(batch, req)
+	}
+
+	var ids []int64
+	if p.outbox != nil {
+		var err error
+		ids, err = p.outbox.add(context.WithoutCancel(ctx), batch)
+		if err != nil {
+			// Sending is still worth a try, only without a safety net.
+			p.logger.Errorf("Failed to write batch of %d tasks to the outbox: %v", len(batch), err)
+		}
+		p.updateOutboxDepth(ctx)
+	}
+	return p.deliver(ctx, ids, batch)
+}
+
+// deliver sends the batch and settles its outbox entries, if any: delivered
+// once the consumer accepts it, rejected once it refuses it for good. After
+// a retryable error the entries stay pending for a later replay, and deliver
+// reports false. Cancelling ctx stops retries, not a send already under way.
+func (p *Producer) deliver(ctx context.Context, ids []int64, batch []*proto.TaskRequest) bool {
+	span := trace.SpanFromContext(ctx)
+
+	resp, err := p.sendWithRetry(ctx, batch)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		code := status.Code(err)
+		if ids != nil && retryableCodes[code] {
+			p.logger.Warnf("Kept batch of %d tasks in the outbox for a later replay: %v", len(batch), err)
+			return false
+		}
+		p.settle(ctx, ids, outboxRejected, err.Error())
+		p.dropped.WithLabelValues(code.String()).Add(float64(len(batch)))
+		p.logger.Errorf("Dropped batch of %d tasks: %v", len(batch), err)
+		return true
+	}
+
+	p.settle(ctx, ids, outboxDelivered, "")
+	for i, taskResp := range resp.Responses {
+		p.logger.Infof("Task sent: id=%d, type=%d, value=%d, status=%s",
+			taskResp.Id, batch[i].Type, batch[i].Value, taskResp.Status)
+	}
+	return true
+}
+
 func main() {
 	if len(os.Args) > 1 && os.Args[1] == "-version" {
 		fmt.Println("Version:", version)
@@ -52,40 +259,79 @@ func main() {
 	logger := shared.InitLogger(config.LogLevel)
 	logger.Info("Producer service started")
 
+	shutdownTracing, err := shared.InitTracing(context.Background(), "producer", config.Tracing)
+	if err != nil {
+		logger.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	registry := shared.NewRegistry()
+	health := shared.NewHealth()
+
+	http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	health.Register(http.DefaultServeMux)
+	metricsServer := &http.Server{Addr: "0.0.0.0:9091"} // Producer metrics on port 9091
 	go func() {
-		http.Handle("/metrics", promhttp.Handler())
-		log.Fatal(http.ListenAndServe("0.0.0.0:9091", nil)) // Producer metrics on port 9091
+		if err := metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
 	}()
 
-	conn, err := grpc.Dial("consumer:50051", grpc.WithInsecure())
+	rpcMetrics := shared.NewClientRPCMetrics()
+	registry.MustRegister(rpcMetrics)
+
+	dialOptions := append([]grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler(
+			otelgrpc.WithFilter(filters.Not(filters.HealthCheck())),
+		)),
+	}, rpcMetrics.DialOptions()...)
+	dialOptions = append(dialOptions, shared.ClientIDDialOptions(config.ClientID)...)
+	conn, err := grpc.Dial("consumer:50051", dialOptions...)
 	if err != nil {
 		logger.Fatalf("Failed to connect to consumer: %v", err)
 	}
 	defer conn.Close()
 
-	taskServiceClient := proto.NewTaskServiceClient(conn)
+	// The producer is ready while the consumer accepts tasks.
+	health.AddCheck("consumer", shared.GRPCHealthCheck(conn, proto.TaskService_ServiceDesc.ServiceName))
 
-	for i := 0; i < config.MaxBacklog; i++ {
-		taskType, taskValue := produceTask()
-
-		logger.Infof("Produced task type: %d, value: %d", taskType, taskValue)
-
-		taskCounter.With(prometheus.Labels{"type": strconv.Itoa(taskType)}).Inc()
-
-		taskRequest := &proto.TaskRequest{
-			Type:  int32(taskType),
-			Value: int32(taskValue),
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		_, err := taskServiceClient.SendTask(context.Background(), taskRequest)
+	producer := NewProducer(proto.NewTaskServiceClient(conn), registry, logger, config.BatchSize)
+	producer.retry = withSendRetryDefaults(config.SendRetry)
+	if config.OutboxPath != "" {
+		producer.outbox, err = openOutbox(context.Background(), config.OutboxPath)
 		if err != nil {
-			logger.Errorf("Failed to send task: %v", err)
-		} else {
-			logger.Infof("Task sent successfully: type=%d, value=%d", taskType, taskValue)
+			logger.Fatalf("Failed to open the outbox: %v", err)
 		}
-
-		time.Sleep(time.Duration(100) * time.Millisecond)
+		defer producer.outbox.Close()
 	}
+	done := make(chan struct{})
+	go func() {
+		producer.Run(ctx, config.MaxBacklog)
+		if producer.outbox != nil {
+			// Keep sending what the run left in the outbox until shutdown.
+			producer.replayEvery(ctx, outboxReplayInterval)
+		}
+		close(done)
+	}()
 
-	select {}
+	<-ctx.Done()
+	stop()
+	logger.Info("Shutting down, waiting for outstanding sends")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout())
+	defer cancel()
+
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		logger.Warn("Shutdown deadline passed before the last batch was answered")
+	}
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		logger.Warnf("Failed to stop the metrics server: %v", err)
+	}
+	logger.Info("Producer stopped")
 }
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang-assessment/golang-assessment/proto"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	_ "modernc.org/sqlite"
+)
+
+// States of an outbox entry.
+const (
+	outboxPending   = "pending"
+	outboxDelivered = "delivered"
+	outboxRejected  = "rejected"
+)
+
+// outboxRetention is how long settled entries are kept before the next start
+// removes them.
+const outboxRetention = 24 * time.Hour
+
+// outboxReplayInterval is how often a producer done producing sends the tasks
+// still pending in the outbox again.
+const outboxReplayInterval = 10 * time.Second
+
+const outboxSchema = `
+CREATE TABLE IF NOT EXISTS outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    type INTEGER NOT NULL,
+    value INTEGER NOT NULL,
+    state TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    last_error TEXT
+);
+CREATE INDEX IF NOT EXISTS outbox_state_id_idx ON outbox (state, id);
+`
+
+// outbox keeps the tasks the producer generates in a SQLite file until the
+// consumer accepts them, so that tasks produced while the consumer is down
+// are sent once it is back, even after a restart.
+type outbox struct {
+	db *sql.DB
+}
+
+// outboxEntry is a task waiting in the outbox.
+type outboxEntry struct {
+	ID  int64
+	Req *proto.TaskRequest
+}
+
+// openOutbox opens the outbox at path, creating it if needed, and removes
+// the entries settled more than outboxRetention ago.
+func openOutbox(ctx context.Context, path string) (*outbox, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the outbox: %v", err)
+	}
+	// A single connection serializes the writes of the producer.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.ExecContext(ctx, outboxSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create the outbox: %v", err)
+	}
+	cutoff := time.Now().UTC().Add(-outboxRetention)
+	if _, err := db.ExecContext(ctx, "DELETE FROM outbox WHERE state != ? AND updated_at < ?", outboxPending, cutoff); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prune the outbox: %v", err)
+	}
+	return &outbox{db: db}, nil
+}
+
+func (o *outbox) Close() error {
+	return o.db.Close()
+}
+
+// add stores the batch as pending in a single transaction and returns the
+// IDs of its entries.
+func (o *outbox) add(ctx context.Context, batch []*proto.TaskRequest) ([]int64, error) {
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	ids := make([]int64, len(batch))
+	for i, req := range batch {
+		err := tx.QueryRowContext(ctx,
+			"INSERT INTO outbox (type, value, state, created_at, updated_at) VALUES (?, ?, ?, ?, ?) RETURNING id",
+			req.Type, req.Value, outboxPending, now, now,
+		).Scan(&ids[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// settle moves the entries out of pending, to delivered or rejected with the
+// error the consumer gave.
+func (o *outbox) settle(ctx context.Context, ids []int64, state, lastError string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := []any{state, time.Now().UTC(), sql.NullString{String: lastError, Valid: lastError != ""}}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	_, err := o.db.ExecContext(ctx,
+		"UPDATE outbox SET state = ?, updated_at = ?, last_error = ? WHERE id IN ("+placeholders+")",
+		args...,
+	)
+	return err
+}
+
+// pending returns up to limit pending entries with an ID above afterID, in
+// ID order.
+func (o *outbox) pending(ctx context.Context, afterID int64, limit int) ([]outboxEntry, error) {
+	rows, err := o.db.QueryContext(ctx,
+		"SELECT id, type, value FROM outbox WHERE state = ? AND id > ? ORDER BY id LIMIT ?",
+		outboxPending, afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []outboxEntry
+	for rows.Next() {
+		entry := outboxEntry{Req: &proto.TaskRequest{}}
+		if err := rows.Scan(&entry.ID, &entry.Req.Type, &entry.Req.Value); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// depth returns the number of pending entries.
+func (o *outbox) depth(ctx context.Context) (int, error) {
+	var n int
+	err := o.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM outbox WHERE state = ?", outboxPending).Scan(&n)
+	return n, err
+}
+
+// replay sends the tasks left pending in the outbox, oldest first, in
+// batches of its own trace, pausing between them while the consumer pushes
+// back. It stops at the first batch that stays pending, since the consumer is
+// still turning tasks away, or once ctx is cancelled.
+func (p *Producer) replay(ctx context.Context) {
+	p.updateOutboxDepth(ctx)
+
+	var afterID int64
+	for ctx.Err() == nil {
+		entries, err := p.outbox.pending(ctx, afterID, p.batchSize)
+		if err != nil {
+			p.logger.Errorf("Failed to read the outbox: %v", err)
+			return
+		}
+		if len(entries) == 0 {
+			return
+		}
+
+		ids := make([]int64, len(entries))
+		batch := make([]*proto.TaskRequest, len(entries))
+		for i, entry := range entries {
+			ids[i] = entry.ID
+			batch[i] = entry.Req
+		}
+		if sleep(ctx, p.pause) != nil {
+			return
+		}
+		p.logger.Infof("Replaying batch of %d tasks from the outbox", len(batch))
+
+		batchCtx, span := tracer().Start(ctx, "replayBatch", trace.WithAttributes(attribute.Int("tasks.count", len(batch))))
+		delivered := p.deliver(batchCtx, ids, batch)
+		span.End()
+		if !delivered {
+			return
+		}
+		afterID = ids[len(ids)-1]
+	}
+}
+
+// replayEvery replays the outbox every interval while it holds pending tasks,
+// until ctx is cancelled.
+func (p *Producer) replayEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if p.outboxDepth > 0 {
+				p.replay(ctx)
+			}
+		}
+	}
+}
+
+// settle records the outcome of a batch in the outbox.
+func (p *Producer) settle(ctx context.Context, ids []int64, state, lastError string) {
+	if p.outbox == nil || len(ids) == 0 {
+		return
+	}
+	// The consumer has answered, so record it even if ctx is cancelled.
+	if err := p.outbox.settle(context.WithoutCancel(ctx), ids, state, lastError); err != nil {
+		p.logger.Errorf("Failed to mark %d tasks %s in the outbox: %v", len(ids), state, err)
+	}
+	p.updateOutboxDepth(ctx)
+}
+
+// updateOutboxDepth sets the outbox depth gauge from the outbox.
+func (p *Producer) updateOutboxDepth(ctx context.Context) {
+	n, err := p.outbox.depth(context.WithoutCancel(ctx))
+	if err != nil {
+		p.logger.Errorf("Failed to count the tasks in the outbox: %v", err)
+		return
+	}
+	p.outboxDepth = n
+	p.outboxSize.Set(float64(n))
+}
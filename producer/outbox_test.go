@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang-assessment/golang-assessment/proto"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestOutbox opens an outbox in a temporary file, closed at the end of
+// the test.
+func newTestOutbox(t *testing.T, path string) *outbox {
+	t.Helper()
+
+	o, err := openOutbox(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Error opening outbox: %v", err)
+	}
+	t.Cleanup(func() { o.Close() })
+	return o
+}
+
+func expectOutboxDepth(t *testing.T, o *outbox, want int) {
+	t.Helper()
+
+	n, err := o.depth(context.Background())
+	if err != nil {
+		t.Fatalf("Error counting the outbox: %v", err)
+	}
+	if n != want {
+		t.Errorf("Expected %d pending tasks in the outbox, got %d", want, n)
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	o := newTestOutbox(t, path)
+	ctx := context.Background()
+
+	ids, err := o.add(ctx, []*proto.TaskRequest{{Type: 1, Value: 10}, {Type: 2, Value: 20}, {Type: 3, Value: 30}})
+	if err != nil {
+		t.Fatalf("Error adding to the outbox: %v", err)
+	}
+	if len(ids) != 3 || ids[1] <= ids[0] || ids[2] <= ids[1] {
+		t.Fatalf("Expected 3 increasing IDs, got %v", ids)
+	}
+	expectOutboxDepth(t, o, 3)
+
+	if err := o.settle(ctx, ids[:1], outboxDelivered, ""); err != nil {
+		t.Fatalf("Error settling entries: %v", err)
+	}
+	if err := o.settle(ctx, ids[2:], outboxRejected, "unknown task type 3"); err != nil {
+		t.Fatalf("Error settling entries: %v", err)
+	}
+	expectOutboxDepth(t, o, 1)
+
+	// Pending entries survive a restart, and settled ones are pruned once
+	// they are old enough.
+	o.Close()
+	o = newTestOutbox(t, path)
+	entries, err := o.pending(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("Error listing the outbox: %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != ids[1] || entries[0].Req.Type != 2 || entries[0].Req.Value != 20 {
+		t.Errorf("Expected only entry %d pending, got %+v", ids[1], entries)
+	}
+	if entries, _ := o.pending(ctx, ids[1], 10); len(entries) != 0 {
+		t.Errorf("Expected no pending entry after %d, got %+v", ids[1], entries)
+	}
+
+	old := time.Now().UTC().Add(-2 * outboxRetention)
+	if _, err := o.db.Exec("UPDATE outbox SET updated_at = ? WHERE id = ?", old, ids[0]); err != nil {
+		t.Fatalf("Error aging entry: %v", err)
+	}
+	o.Close()
+	o = newTestOutbox(t, path)
+	var rows int
+	if err := o.db.QueryRow("SELECT COUNT(*) FROM outbox").Scan(&rows); err != nil {
+		t.Fatalf("Error counting entries: %v", err)
+	}
+	if rows != 2 {
+		t.Errorf("Expected the old delivered entry to be pruned, leaving 2 entries, got %d", rows)
+	}
+}
+
+func TestRunReplaysOutbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+
+	// The consumer is down: the batch stays in the outbox.
+	down := &flakyTaskServiceServer{err: status.Error(codes.Unavailable, "connection refused"), failures: -1}
+	p := newTestProducer(t, startMockConsumer(t, down), 2)
+	p.outbox = newTestOutbox(t, path)
+	p.Run(context.Background(), 2)
+
+	expectOutboxDepth(t, p.outbox, 2)
+	if n := testutil.ToFloat64(p.outboxSize); n != 2 {
+		t.Errorf("Expected the outbox depth gauge at 2, got %v", n)
+	}
+	if n := testutil.CollectAndCount(p.dropped); n != 0 {
+		t.Errorf("Expected no dropped tasks, got %d series", n)
+	}
+	p.outbox.Close()
+
+	// The next run sends them before producing anything.
+	up := &mockTaskServiceServer{}
+	p = newTestProducer(t, startMockConsumer(t, up), 2)
+	p.outbox = newTestOutbox(t, path)
+	p.Run(context.Background(), 0)
+
+	if len(up.received) != 2 {
+		t.Fatalf("Expected the 2 tasks of the outbox to be replayed, got %d", len(up.received))
+	}
+	expectOutboxDepth(t, p.outbox, 0)
+	if n := testutil.ToFloat64(p.outboxSize); n != 0 {
+		t.Errorf("Expected the outbox depth gauge at 0, got %v", n)
+	}
+}
+
+func TestRunReplaysOutboxOnceBatchesGetThrough(t *testing.T) {
+	// The first batch is turned away, the second gets through.
+	mock := &flakyTaskServiceServer{err: status.Error(codes.Unavailable, "connection refused"), failures: 1}
+	p := newTestProducer(t, startMockConsumer(t, mock), 1)
+	p.outbox = newTestOutbox(t, filepath.Join(t.TempDir(), "outbox.db"))
+
+	p.Run(context.Background(), 4)
+
+	if len(mock.received) != 4 {
+		t.Errorf("Expected the kept batch to be replayed after the next one, got %d tasks", len(mock.received))
+	}
+	expectOutboxDepth(t, p.outbox, 0)
+}
+
+func TestReplayEvery(t *testing.T) {
+	mock := &flakyTaskServiceServer{err: status.Error(codes.Unavailable, "connection refused"), failures: 1}
+	p := newTestProducer(t, startMockConsumer(t, mock), 1)
+	p.outbox = newTestOutbox(t, filepath.Join(t.TempDir(), "outbox.db"))
+
+	p.Run(context.Background(), 2)
+	expectOutboxDepth(t, p.outbox, 2)
+
+	// The consumer is back, but nothing else is produced.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	p.replayEvery(ctx, 10*time.Millisecond)
+
+	if len(mock.received) != 2 {
+		t.Errorf("Expected the outbox to be replayed, got %d tasks", len(mock.received))
+	}
+	expectOutboxDepth(t, p.outbox, 0)
+	if n := testutil.ToFloat64(p.outboxSize); n != 0 {
+		t.Errorf("Expected the outbox depth gauge at 0, got %v", n)
+	}
+}
+
+func TestRunSettlesRejectedBatches(t *testing.T) {
+	mock := &flakyTaskServiceServer{err: status.Error(codes.InvalidArgument, "unknown task type 12"), failures: -1}
+	p := newTestProducer(t, startMockConsumer(t, mock), 3)
+	p.outbox = newTestOutbox(t, filepath.Join(t.TempDir(), "outbox.db"))
+
+	p.Run(context.Background(), 2)
+	p.Run(context.Background(), 0)
+
+	if len(mock.attempts) != 1 {
+		t.Errorf("Expected a rejected batch not to be replayed, got %d attempts", len(mock.attempts))
+	}
+	expectOutboxDepth(t, p.outbox, 0)
+	if n := testutil.ToFloat64(p.dropped.WithLabelValues("InvalidArgument")); n != 2 {
+		t.Errorf("Expected 2 dropped tasks, got %v", n)
+	}
+}
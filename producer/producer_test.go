@@ -1,7 +1,28 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"golang-assessment/golang-assessment/proto"
+	"golang-assessment/monitoring"
+	"golang-assessment/shared"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 func TestTaskProduction(t *testing.T) {
@@ -15,3 +36,202 @@ func TestTaskProduction(t *testing.T) {
 		t.Errorf("Invalid task value: %d", taskValue)
 	}
 }
+
+type mockTaskServiceServer struct {
+	proto.UnimplementedTaskServiceServer
+	received []*proto.TaskRequest
+}
+
+func (m *mockTaskServiceServer) SendTasks(stream grpc.ClientStreamingServer[proto.TaskRequest, proto.SendTasksResponse]) error {
+	resp := &proto.SendTasksResponse{}
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(resp)
+		}
+		if err != nil {
+			return err
+		}
+		m.received = append(m.received, req)
+		resp.Responses = append(resp.Responses, &proto.TaskResponse{
+			Status: "Task saved successfully",
+			Id:     int32(len(m.received)),
+		})
+	}
+}
+
+// startMockConsumer serves m over an in-memory listener and returns a client
+// connected to it.
+func startMockConsumer(t *testing.T, m proto.TaskServiceServer, opts ...grpc.DialOption) proto.TaskServiceClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	proto.RegisterTaskServiceServer(grpcServer, m)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet", append([]grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, opts...)...)
+	if err != nil {
+		t.Fatalf("Failed to dial mock consumer: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return proto.NewTaskServiceClient(conn)
+}
+
+func TestSendBatch(t *testing.T) {
+	mock := &mockTaskServiceServer{}
+	client := startMockConsumer(t, mock)
+
+	batch := []*proto.TaskRequest{
+		{Type: 1, Value: 10},
+		{Type: 2, Value: 20},
+		{Type: 3, Value: 30},
+	}
+
+	resp, _, err := sendBatch(context.Background(), client, batch)
+	if err != nil {
+		t.Fatalf("Error in sendBatch: %v", err)
+	}
+
+	if len(resp.Responses) != len(batch) {
+		t.Errorf("Expected %d responses, got %d", len(batch), len(resp.Responses))
+	}
+	if len(mock.received) != len(batch) {
+		t.Fatalf("Expected consumer to receive %d tasks, got %d", len(batch), len(mock.received))
+	}
+	for i, req := range mock.received {
+		if req.Type != batch[i].Type || req.Value != batch[i].Value {
+			t.Errorf("Task %d: expected %+v, got %+v", i, batch[i], req)
+		}
+	}
+}
+
+func TestProducerRunRecordsMetrics(t *testing.T) {
+	mock := &mockTaskServiceServer{}
+	client := startMockConsumer(t, mock)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// Each producer has its own registry, so several can live in one process.
+	reg := prometheus.NewRegistry()
+	p := NewProducer(client, reg, logger, 2)
+	NewProducer(client, prometheus.NewRegistry(), logger, 2)
+
+	p.Run(context.Background(), 5)
+
+	if len(mock.received) != 5 {
+		t.Fatalf("Expected consumer to receive 5 tasks, got %d", len(mock.received))
+	}
+
+	counts := make(map[int32]int)
+	sums := make(map[int32]int32)
+	for _, req := range mock.received {
+		counts[req.Type]++
+		sums[req.Type] += req.Value
+	}
+
+	var expected strings.Builder
+	expected.WriteString("# HELP tasks_produced_total Total number of tasks produced\n")
+	expected.WriteString("# TYPE tasks_produced_total counter\n")
+	for taskType, n := range counts {
+		fmt.Fprintf(&expected, "tasks_produced_total{type=\"%d\"} %d\n", taskType, n)
+	}
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected.String()), "tasks_produced_total"); err != nil {
+		t.Errorf("Unexpected tasks_produced_total: %v", err)
+	}
+
+	for taskType, sum := range sums {
+		metric := &dto.Metric{}
+		if err := p.values.WithLabelValues(strconv.Itoa(int(taskType))).(prometheus.Metric).Write(metric); err != nil {
+			t.Fatalf("Error writing metric: %v", err)
+		}
+		if got := metric.Histogram.GetSampleSum(); got != float64(sum) {
+			t.Errorf("Expected produced value sum %d for type %d, got %v", sum, taskType, got)
+		}
+		if got := metric.Histogram.GetSampleCount(); got != uint64(counts[taskType]) {
+			t.Errorf("Expected %d produced values for type %d, got %d", counts[taskType], taskType, got)
+		}
+	}
+}
+
+// TestMonitoringQueriesUseExportedMetrics checks the producer series used by
+// the shipped Prometheus rules and Grafana dashboards against the metrics a
+// producer exports once it has retried and dropped a batch.
+func TestMonitoringQueriesUseExportedMetrics(t *testing.T) {
+	queries, err := monitoring.Load("../monitoring")
+	if err != nil {
+		t.Fatalf("Error loading monitoring queries: %v", err)
+	}
+
+	reg := shared.NewRegistry()
+	rpcMetrics := shared.NewClientRPCMetrics()
+	reg.MustRegister(rpcMetrics)
+	mock := &flakyTaskServiceServer{err: status.Error(codes.Unavailable, "unavailable"), failures: -1}
+	client := startMockConsumer(t, mock, rpcMetrics.DialOptions()...)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	p := NewProducer(client, reg, logger, 2)
+	p.retry = shared.RetryPolicy{MaxAttempts: 2, InitialBackoffMs: 1, MaxBackoffMs: 1, Multiplier: 1}
+	p.Run(context.Background(), 2)
+
+	exported, err := monitoring.ExportedNames(reg)
+	if err != nil {
+		t.Fatalf("Error gathering metrics: %v", err)
+	}
+	for _, s := range queries.MissingForJob("producer", exported) {
+		t.Errorf("%s: the producer does not export %s", s.Source, s.Metric)
+	}
+}
+
+// blockingTaskServiceServer holds each SendTasks stream open until released.
+type blockingTaskServiceServer struct {
+	mockTaskServiceServer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (m *blockingTaskServiceServer) SendTasks(stream grpc.ClientStreamingServer[proto.TaskRequest, proto.SendTasksResponse]) error {
+	m.started <- struct{}{}
+	<-m.release
+	return m.mockTaskServiceServer.SendTasks(stream)
+}
+
+func TestRunFinishesSendOnCancel(t *testing.T) {
+	mock := &blockingTaskServiceServer{started: make(chan struct{}, 1), release: make(chan struct{})}
+	client := startMockConsumer(t, mock)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	p := NewProducer(client, prometheus.NewRegistry(), logger, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx, 10)
+		close(done)
+	}()
+
+	<-mock.started
+	cancel()
+	select {
+	case <-done:
+		t.Fatalf("Expected Run to wait for the batch being sent")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(mock.release)
+	<-done
+
+	if len(mock.received) != 2 {
+		t.Errorf("Expected only the batch under way to be delivered, got %d tasks", len(mock.received))
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"golang-assessment/golang-assessment/proto"
+	"golang-assessment/shared"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// defaultSendRetry fills in every setting the configured SendRetry leaves out.
+var defaultSendRetry = shared.RetryPolicy{
+	MaxAttempts:      5,
+	InitialBackoffMs: 200,
+	MaxBackoffMs:     10000,
+	Multiplier:       2,
+}
+
+// retryableCodes are the codes a batch is sent again for. The consumer
+// rejects them before it stores anything, except for Unavailable, which may
+// also mean the connection broke after the batch was stored: such a batch is
+// delivered twice.
+var retryableCodes = map[codes.Code]bool{
+	codes.Unavailable:       true,
+	codes.ResourceExhausted: true,
+	codes.Aborted:           true,
+}
+
+// withSendRetryDefaults replaces the unset fields of policy with those of
+// defaultSendRetry.
+func withSendRetryDefaults(policy shared.RetryPolicy) shared.RetryPolicy {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = defaultSendRetry.MaxAttempts
+	}
+	if policy.InitialBackoffMs <= 0 {
+		policy.InitialBackoffMs = defaultSendRetry.InitialBackoffMs
+	}
+	if policy.MaxBackoffMs <= 0 {
+		policy.MaxBackoffMs = defaultSendRetry.MaxBackoffMs
+	}
+	if policy.Multiplier < 1 {
+		policy.Multiplier = defaultSendRetry.Multiplier
+	}
+	return policy
+}
+
+// backoff returns how long to wait after the given number of failed
+// attempts: the exponential backoff of the policy, of which a random half is
+// taken off so that producers failing together do not retry together.
+func backoff(policy shared.RetryPolicy, attempts int) time.Duration {
+	ms := float64(policy.InitialBackoffMs) * math.Pow(policy.Multiplier, float64(attempts-1))
+	ms = min(ms, float64(policy.MaxBackoffMs))
+	ms = ms/2 + rand.Float64()*ms/2
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// retryAfter returns the wait the consumer asked for in the trailer, or zero.
+func retryAfter(trailer metadata.MD) time.Duration {
+	values := trailer.Get(shared.RetryAfterMetadataKey)
+	if len(values) == 0 {
+		return 0
+	}
+	ms, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil || ms < 0 {
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// sendWithRetry sends the batch, and sends it again after a retryable error
+// until it is accepted or the policy runs out of attempts. Each wait is the
+// longer of the backoff and the consumer's retry-after. A send under way
+// finishes even if ctx is cancelled, but ctx ends the waits.
+func (p *Producer) sendWithRetry(ctx context.Context, batch []*proto.TaskRequest) (*proto.SendTasksResponse, error) {
+	sendCtx := context.WithoutCancel(ctx)
+	for attempt := 1; ; attempt++ {
+		resp, trailer, err := sendBatch(sendCtx, p.client, batch)
+		code := status.Code(err)
+		switch {
+		case err == nil:
+			p.relieve()
+		case code == codes.ResourceExhausted:
+			p.backpressure(retryAfter(trailer))
+		}
+		if err == nil || !retryableCodes[code] || attempt >= p.retry.MaxAttempts {
+			return resp, err
+		}
+
+		wait := max(backoff(p.retry, attempt), retryAfter(trailer))
+		p.retries.WithLabelValues(code.String()).Inc()
+		p.logger.Warnf("Failed to send batch of %d tasks (attempt %d of %d), retrying in %s: %v",
+			len(batch), attempt, p.retry.MaxAttempts, wait, err)
+		if sleep(ctx, wait) != nil {
+			return nil, err
+		}
+	}
+}
+
+// backpressure slows the producer down after the consumer turned a batch
+// away: the pause before each batch doubles, to at least wait and at most
+// the longest backoff.
+func (p *Producer) backpressure(wait time.Duration) {
+	step := time.Duration(p.retry.InitialBackoffMs) * time.Millisecond
+	p.setPause(min(max(2*p.pause, wait, step), time.Duration(p.retry.MaxBackoffMs)*time.Millisecond))
+}
+
+// relieve halves the pause before each batch once the consumer accepts one,
+// down to none.
+func (p *Producer) relieve() {
+	pause := p.pause / 2
+	if pause < time.Duration(p.retry.InitialBackoffMs)*time.Millisecond/2 {
+		pause = 0
+	}
+	p.setPause(pause)
+}
+
+func (p *Producer) setPause(pause time.Duration) {
+	p.pause = pause
+	p.pauseGauge.Set(pause.Seconds())
+}
+
+// sleep waits for d, or returns the error of ctx if it ends first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang-assessment/golang-assessment/proto"
+	"golang-assessment/shared"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// flakyTaskServiceServer rejects the first failures SendTasks streams with
+// err and trailer, then accepts the batches.
+type flakyTaskServiceServer struct {
+	mockTaskServiceServer
+	err     error
+	trailer metadata.MD
+
+	mu       sync.Mutex
+	failures int
+	attempts []time.Time
+}
+
+func (m *flakyTaskServiceServer) SendTasks(stream grpc.ClientStreamingServer[proto.TaskRequest, proto.SendTasksResponse]) error {
+	m.mu.Lock()
+	m.attempts = append(m.attempts, time.Now())
+	fail := m.failures != 0
+	if m.failures > 0 {
+		m.failures--
+	}
+	m.mu.Unlock()
+
+	if fail {
+		stream.SetTrailer(m.trailer)
+		return m.err
+	}
+	return m.mockTaskServiceServer.SendTasks(stream)
+}
+
+// newTestProducer returns a producer retrying up to maxAttempts times with
+// short backoffs.
+func newTestProducer(t *testing.T, client proto.TaskServiceClient, maxAttempts int) *Producer {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	p := NewProducer(client, prometheus.NewRegistry(), logger, 2)
+	p.retry = shared.RetryPolicy{MaxAttempts: maxAttempts, InitialBackoffMs: 2, MaxBackoffMs: 10, Multiplier: 2}
+	return p
+}
+
+func TestWithSendRetryDefaults(t *testing.T) {
+	if got := withSendRetryDefaults(shared.RetryPolicy{}); got != defaultSendRetry {
+		t.Errorf("Expected the defaults for an empty policy, got %+v", got)
+	}
+	got := withSendRetryDefaults(shared.RetryPolicy{MaxAttempts: 2})
+	if got.MaxAttempts != 2 || got.InitialBackoffMs != defaultSendRetry.InitialBackoffMs {
+		t.Errorf("Expected MaxAttempts 2 with default backoffs, got %+v", got)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	policy := shared.RetryPolicy{InitialBackoffMs: 100, MaxBackoffMs: 1000, Multiplier: 2}
+	tests := []struct {
+		attempts int
+		ceiling  time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{10, time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if got := backoff(policy, tt.attempts); got < tt.ceiling/2 || got > tt.ceiling {
+				t.Fatalf("Expected backoff after %d attempts within [%v, %v], got %v", tt.attempts, tt.ceiling/2, tt.ceiling, got)
+			}
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		trailer metadata.MD
+		want    time.Duration
+	}{
+		{nil, 0},
+		{metadata.Pairs(shared.RetryAfterMetadataKey, "250"), 250 * time.Millisecond},
+		{metadata.Pairs(shared.RetryAfterMetadataKey, "soon"), 0},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.trailer); got != tt.want {
+			t.Errorf("retryAfter(%v): expected %v, got %v", tt.trailer, tt.want, got)
+		}
+	}
+}
+
+func TestSendRetriesRetryableErrors(t *testing.T) {
+	mock := &flakyTaskServiceServer{err: status.Error(codes.Unavailable, "consumer is shutting down"), failures: 2}
+	p := newTestProducer(t, startMockConsumer(t, mock), 5)
+
+	p.Run(context.Background(), 2)
+
+	if len(mock.attempts) != 3 || len(mock.received) != 2 {
+		t.Fatalf("Expected the batch delivered on the third attempt, got %d attempts and %d tasks", len(mock.attempts), len(mock.received))
+	}
+	if n := testutil.ToFloat64(p.retries.WithLabelValues("Unavailable")); n != 2 {
+		t.Errorf("Expected 2 retries, got %v", n)
+	}
+	if n := testutil.CollectAndCount(p.dropped); n != 0 {
+		t.Errorf("Expected no dropped tasks, got %d series", n)
+	}
+}
+
+func TestSendHonorsRetryAfter(t *testing.T) {
+	mock := &flakyTaskServiceServer{
+		err:      status.Error(codes.ResourceExhausted, "client rate limit exceeded"),
+		trailer:  metadata.Pairs(shared.RetryAfterMetadataKey, "50"),
+		failures: 1,
+	}
+	p := newTestProducer(t, startMockConsumer(t, mock), 3)
+	p.retry.MaxBackoffMs = 1000
+
+	if _, err := p.sendWithRetry(context.Background(), []*proto.TaskRequest{{Type: 1}}); err != nil {
+		t.Fatalf("Error sending batch: %v", err)
+	}
+	if len(mock.attempts) != 2 {
+		t.Fatalf("Expected 2 attempts, got %d", len(mock.attempts))
+	}
+	if wait := mock.attempts[1].Sub(mock.attempts[0]); wait < 50*time.Millisecond {
+		t.Errorf("Expected the retry to wait for retry-after, waited %v", wait)
+	}
+
+	// The rejection raised the pause to retry-after, and the success halved it.
+	if p.pause != 25*time.Millisecond {
+		t.Errorf("Expected a pause of 25ms between batches, got %v", p.pause)
+	}
+	if n := testutil.ToFloat64(p.pauseGauge); n != 0.025 {
+		t.Errorf("Expected the pause gauge at 0.025, got %v", n)
+	}
+	for i := 0; i < 5; i++ {
+		p.relieve()
+	}
+	if p.pause != 0 {
+		t.Errorf("Expected the pause to go away once the consumer keeps up, got %v", p.pause)
+	}
+}
+
+func TestSendDropsBatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+		code     string
+	}{
+		{"retries exhausted", status.Error(codes.Unavailable, "unavailable"), 3, "Unavailable"},
+		{"not retryable", status.Error(codes.InvalidArgument, "unknown task type 12"), 1, "InvalidArgument"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &flakyTaskServiceServer{err: tt.err, failures: -1}
+			p := newTestProducer(t, startMockConsumer(t, mock), 3)
+
+			p.Run(context.Background(), 2)
+
+			if len(mock.attempts) != tt.attempts {
+				t.Errorf("Expected %d attempts, got %d", tt.attempts, len(mock.attempts))
+			}
+			expected := `
+# HELP tasks_dropped_total Number of tasks given up on without the consumer accepting them, by gRPC code
+# TYPE tasks_dropped_total counter
+tasks_dropped_total{code="` + tt.code + `"} 2
+`
+			if err := testutil.CollectAndCompare(p.dropped, strings.NewReader(expected)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestSendStopsRetryingOnCancel(t *testing.T) {
+	mock := &flakyTaskServiceServer{err: status.Error(codes.Unavailable, "unavailable"), failures: -1}
+	p := newTestProducer(t, startMockConsumer(t, mock), 100)
+	p.retry.InitialBackoffMs = 1000
+	p.retry.MaxBackoffMs = 1000
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := p.sendWithRetry(ctx, []*proto.TaskRequest{{Type: 1}})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected the last error once cancelled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("Expected cancelling to end the backoff, took %v", elapsed)
+	}
+}
@@ -0,0 +1,30 @@
+package shared
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Metadata keys shared by the producer and the consumer.
+const (
+	// ClientIDMetadataKey carries the ID a client identifies itself with
+	// when it has no client certificate.
+	ClientIDMetadataKey = "client-id"
+	// RetryAfterMetadataKey is set in the trailer of a throttled RPC to the
+	// milliseconds to wait before trying again.
+	RetryAfterMetadataKey = "retry-after-ms"
+)
+
+// ClientIDDialOptions sends id as the client ID with every RPC.
+func ClientIDDialOptions(id string) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(metadata.AppendToOutgoingContext(ctx, ClientIDMetadataKey, id), method, req, reply, cc, opts...)
+		}),
+		grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(metadata.AppendToOutgoingContext(ctx, ClientIDMetadataKey, id), desc, cc, method, opts...)
+		}),
+	}
+}
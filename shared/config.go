@@ -4,16 +4,103 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 )
 
+// DefaultShutdownTimeout is used when the config sets no ShutdownTimeoutMs.
+const DefaultShutdownTimeout = 30 * time.Second
+
 type Config struct {
-	DatabaseURL     string `json:"DatabaseURL"`
-	LogLevel        string `json:"LogLevel"`
-	ProducerPort    int    `json:"ProducerPort"`
-	ConsumerPort    int    `json:"ConsumerPort"`
-	MaxBacklog      int    `json:"MaxBacklog"`
-	PrometheusPort  int    `json:"PrometheusPort"`
-	ConsumerAddress string `json:"ConsumerAddress"`
+	DatabaseURL       string                 `json:"DatabaseURL"`
+	LogLevel          string                 `json:"LogLevel"`
+	ProducerPort      int                    `json:"ProducerPort"`
+	ConsumerPort      int                    `json:"ConsumerPort"`
+	MaxBacklog        int                    `json:"MaxBacklog"`
+	PrometheusPort    int                    `json:"PrometheusPort"`
+	ConsumerAddress   string                 `json:"ConsumerAddress"`
+	Workers           int                    `json:"Workers"`
+	QueueSize         int                    `json:"QueueSize"`
+	BatchSize         int                    `json:"BatchSize"`
+	Retry             RetryPolicy            `json:"Retry"`
+	RetryByType       map[string]RetryPolicy `json:"RetryByType"`
+	Buckets           HistogramBuckets       `json:"Buckets"`
+	Tracing           TracingConfig          `json:"Tracing"`
+	ShutdownTimeoutMs int                    `json:"ShutdownTimeoutMs"`
+	RateLimits        RateLimits             `json:"RateLimits"`
+	Concurrency       ConcurrencyLimit       `json:"Concurrency"`
+	ClientID          string                 `json:"ClientID"`
+	// SendRetry sets how the producer retries a batch the consumer rejects
+	// with a retryable code.
+	SendRetry RetryPolicy `json:"SendRetry"`
+	// OutboxPath is the SQLite file the producer keeps each task in until
+	// the consumer accepts it. Empty disables the outbox.
+	OutboxPath string `json:"OutboxPath"`
+	// AdminToken is the bearer token every request to the consumer's admin
+	// API requires. The API is not served while it is empty.
+	AdminToken string `json:"AdminToken"`
+	// AdminAddress is the address the admin API listens on, apart from the
+	// metrics.
+	AdminAddress string `json:"AdminAddress"`
+}
+
+// ShutdownTimeout is how long a service may take to drain once asked to stop.
+func (c *Config) ShutdownTimeout() time.Duration {
+	if c.ShutdownTimeoutMs <= 0 {
+		return DefaultShutdownTimeout
+	}
+	return time.Duration(c.ShutdownTimeoutMs) * time.Millisecond
+}
+
+// HistogramBuckets holds the upper bounds, in seconds, of the buckets of the
+// consumer's latency histograms. An empty list keeps the built-in buckets.
+type HistogramBuckets struct {
+	Handler  []float64 `json:"Handler"`
+	EndToEnd []float64 `json:"EndToEnd"`
+	SaveTask []float64 `json:"SaveTask"`
+}
+
+// RateLimit admits Rate tasks per second on average, in bursts of up to
+// Burst tasks. A Rate of zero or less removes the limit.
+type RateLimit struct {
+	Rate  float64 `json:"Rate"`
+	Burst int     `json:"Burst"`
+}
+
+// RateLimits sets how many tasks the consumer accepts. A task must fit both
+// the limit of the client sending it and the limit of its type.
+type RateLimits struct {
+	// PerClient applies to each client separately, and Clients overrides it
+	// by client ID.
+	PerClient RateLimit            `json:"PerClient"`
+	Clients   map[string]RateLimit `json:"Clients"`
+	// PerType applies to each task type separately, whoever sends it, and
+	// Types overrides it by task type.
+	PerType RateLimit            `json:"PerType"`
+	Types   map[string]RateLimit `json:"Types"`
+}
+
+// ConcurrencyLimit sets the adaptive limit on the SendTask and SendTasks
+// calls the consumer admits at once. The limit starts at Initial, shrinks by
+// the Backoff factor whenever saving tasks takes longer than LatencyTargetMs
+// or the queue is full, and grows by one while it is healthy and in use,
+// within Min and Max. A Max of zero or less turns the limit off.
+type ConcurrencyLimit struct {
+	Initial         int     `json:"Initial"`
+	Min             int     `json:"Min"`
+	Max             int     `json:"Max"`
+	LatencyTargetMs int     `json:"LatencyTargetMs"`
+	Backoff         float64 `json:"Backoff"`
+}
+
+// RetryPolicy controls how often a failing task, or a batch the producer
+// sends, is attempted and how long to wait between attempts. The wait starts
+// at InitialBackoffMs and is multiplied by Multiplier after every failed
+// attempt, up to MaxBackoffMs.
+type RetryPolicy struct {
+	MaxAttempts      int     `json:"MaxAttempts"`
+	InitialBackoffMs int     `json:"InitialBackoffMs"`
+	MaxBackoffMs     int     `json:"MaxBackoffMs"`
+	Multiplier       float64 `json:"Multiplier"`
 }
 
 func LoadConfig() (*Config, error) {
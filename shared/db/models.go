@@ -5,6 +5,7 @@
 package db
 
 import (
+	"database/sql"
 	"time"
 )
 
@@ -15,4 +16,18 @@ type Task struct {
 	State          string
 	CreationTime   time.Time
 	LastUpdateTime time.Time
+	Comment        sql.NullString
+	Attempts       int32
+	NextRunAt      sql.NullTime
+	LastError      sql.NullString
+	Result         sql.NullString
+	Version        int32
+}
+
+type RateLimitChange struct {
+	ID        int32
+	ChangedAt time.Time
+	Actor     string
+	Previous  string
+	Limits    string
 }
@@ -0,0 +1,99 @@
+package postgres
+
+import (
+	"context"
+
+	"golang-assessment/shared/db"
+)
+
+// adapter exposes the PostgreSQL queries as a db.Querier. Both packages are
+// generated from the same queries, so their types convert directly.
+type adapter struct {
+	q *Queries
+}
+
+// NewQuerier returns a db.Querier running the PostgreSQL queries on dbtx.
+func NewQuerier(dbtx db.DBTX) db.Querier {
+	return adapter{q: New(dbtx)}
+}
+
+func (a adapter) CountTasksByState(ctx context.Context) ([]db.CountTasksByStateRow, error) {
+	rows, err := a.q.CountTasksByState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]db.CountTasksByStateRow, len(rows))
+	for i, row := range rows {
+		items[i] = db.CountTasksByStateRow(row)
+	}
+	return items, nil
+}
+
+func (a adapter) CreateTask(ctx context.Context, arg db.CreateTaskParams) (int32, error) {
+	return a.q.CreateTask(ctx, CreateTaskParams(arg))
+}
+
+func (a adapter) CreateRateLimitChange(ctx context.Context, arg db.CreateRateLimitChangeParams) (int32, error) {
+	return a.q.CreateRateLimitChange(ctx, CreateRateLimitChangeParams(arg))
+}
+
+func (a adapter) GetTaskById(ctx context.Context, id int32) (db.Task, error) {
+	task, err := a.q.GetTaskById(ctx, id)
+	return db.Task(task), err
+}
+
+func (a adapter) ListTasks(ctx context.Context, arg db.ListTasksParams) ([]db.Task, error) {
+	tasks, err := a.q.ListTasks(ctx, ListTasksParams(arg))
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]db.Task, len(tasks))
+	for i, task := range tasks {
+		items[i] = db.Task(task)
+	}
+	return items, nil
+}
+
+func (a adapter) ListDueTasks(ctx context.Context, arg db.ListDueTasksParams) ([]db.Task, error) {
+	tasks, err := a.q.ListDueTasks(ctx, ListDueTasksParams(arg))
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]db.Task, len(tasks))
+	for i, task := range tasks {
+		items[i] = db.Task(task)
+	}
+	return items, nil
+}
+
+func (a adapter) ListRateLimitChanges(ctx context.Context, limit int32) ([]db.RateLimitChange, error) {
+	changes, err := a.q.ListRateLimitChanges(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]db.RateLimitChange, len(changes))
+	for i, change := range changes {
+		items[i] = db.RateLimitChange(change)
+	}
+	return items, nil
+}
+
+func (a adapter) RecordTaskFailure(ctx context.Context, arg db.RecordTaskFailureParams) error {
+	return a.q.RecordTaskFailure(ctx, RecordTaskFailureParams(arg))
+}
+
+func (a adapter) RecordTaskResult(ctx context.Context, arg db.RecordTaskResultParams) error {
+	return a.q.RecordTaskResult(ctx, RecordTaskResultParams(arg))
+}
+
+func (a adapter) RequeueDeadTask(ctx context.Context, arg db.RequeueDeadTaskParams) (int64, error) {
+	return a.q.RequeueDeadTask(ctx, RequeueDeadTaskParams(arg))
+}
+
+func (a adapter) UpdateTaskState(ctx context.Context, arg db.UpdateTaskStateParams) error {
+	return a.q.UpdateTaskState(ctx, UpdateTaskStateParams(arg))
+}
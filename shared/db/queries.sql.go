@@ -7,12 +7,73 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"time"
 )
 
-const createTask = `-- name: CreateTask :exec
+const countTasksByState = `-- name: CountTasksByState :many
+SELECT state, COUNT(*) AS count
+FROM tasks
+GROUP BY state
+`
+
+type CountTasksByStateRow struct {
+	State string
+	Count int64
+}
+
+func (q *Queries) CountTasksByState(ctx context.Context) ([]CountTasksByStateRow, error) {
+	rows, err := q.db.QueryContext(ctx, countTasksByState)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountTasksByStateRow
+	for rows.Next() {
+		var i CountTasksByStateRow
+		if err := rows.Scan(&i.State, &i.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const createRateLimitChange = `-- name: CreateRateLimitChange :one
+INSERT INTO rate_limit_changes (changed_at, actor, previous, limits)
+VALUES ($1, $2, $3, $4)
+RETURNING id
+`
+
+type CreateRateLimitChangeParams struct {
+	ChangedAt time.Time
+	Actor     string
+	Previous  string
+	Limits    string
+}
+
+func (q *Queries) CreateRateLimitChange(ctx context.Context, arg CreateRateLimitChangeParams) (int32, error) {
+	row := q.db.QueryRowContext(ctx, createRateLimitChange,
+		arg.ChangedAt,
+		arg.Actor,
+		arg.Previous,
+		arg.Limits,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createTask = `-- name: CreateTask :one
 INSERT INTO tasks (type, value, state, creation_time, last_update_time)
 VALUES ($1, $2, $3, $4, $5)
+RETURNING id
 `
 
 type CreateTaskParams struct {
@@ -23,19 +84,21 @@ type CreateTaskParams struct {
 	LastUpdateTime time.Time
 }
 
-func (q *Queries) CreateTask(ctx context.Context, arg CreateTaskParams) error {
-	_, err := q.db.ExecContext(ctx, createTask,
+func (q *Queries) CreateTask(ctx context.Context, arg CreateTaskParams) (int32, error) {
+	row := q.db.QueryRowContext(ctx, createTask,
 		arg.Type,
 		arg.Value,
 		arg.State,
 		arg.CreationTime,
 		arg.LastUpdateTime,
 	)
-	return err
+	var id int32
+	err := row.Scan(&id)
+	return id, err
 }
 
 const getTaskById = `-- name: GetTaskById :one
-SELECT id, type, value, state, creation_time, last_update_time
+SELECT id, type, value, state, creation_time, last_update_time, comment, attempts, next_run_at, last_error, result, version
 FROM tasks
 WHERE id = $1
 `
@@ -50,13 +113,238 @@ func (q *Queries) GetTaskById(ctx context.Context, id int32) (Task, error) {
 		&i.State,
 		&i.CreationTime,
 		&i.LastUpdateTime,
+		&i.Comment,
+		&i.Attempts,
+		&i.NextRunAt,
+		&i.LastError,
+		&i.Result,
+		&i.Version,
 	)
 	return i, err
 }
 
+const listDueTasks = `-- name: ListDueTasks :many
+SELECT id, type, value, state, creation_time, last_update_time, comment, attempts, next_run_at, last_error, result, version
+FROM tasks
+WHERE state = 'retrying' AND next_run_at <= $1
+ORDER BY next_run_at, id
+LIMIT $2
+`
+
+type ListDueTasksParams struct {
+	NextRunAt sql.NullTime
+	PageLimit int32
+}
+
+func (q *Queries) ListDueTasks(ctx context.Context, arg ListDueTasksParams) ([]Task, error) {
+	rows, err := q.db.QueryContext(ctx, listDueTasks, arg.NextRunAt, arg.PageLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Type,
+			&i.Value,
+			&i.State,
+			&i.CreationTime,
+			&i.LastUpdateTime,
+			&i.Comment,
+			&i.Attempts,
+			&i.NextRunAt,
+			&i.LastError,
+			&i.Result,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRateLimitChanges = `-- name: ListRateLimitChanges :many
+SELECT id, changed_at, actor, previous, limits
+FROM rate_limit_changes
+ORDER BY id DESC
+LIMIT $1
+`
+
+func (q *Queries) ListRateLimitChanges(ctx context.Context, limit int32) ([]RateLimitChange, error) {
+	rows, err := q.db.QueryContext(ctx, listRateLimitChanges, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RateLimitChange
+	for rows.Next() {
+		var i RateLimitChange
+		if err := rows.Scan(
+			&i.ID,
+			&i.ChangedAt,
+			&i.Actor,
+			&i.Previous,
+			&i.Limits,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTasks = `-- name: ListTasks :many
+SELECT id, type, value, state, creation_time, last_update_time, comment, attempts, next_run_at, last_error, result, version
+FROM tasks
+WHERE id > $1
+  AND ($2 IS NULL OR state = $2)
+  AND ($3 IS NULL OR type = $3)
+  AND ($4 IS NULL OR creation_time >= $4)
+  AND ($5 IS NULL OR creation_time < $5)
+ORDER BY id
+LIMIT $6
+`
+
+type ListTasksParams struct {
+	AfterID       int32
+	State         sql.NullString
+	Type          sql.NullInt32
+	CreatedAfter  sql.NullTime
+	CreatedBefore sql.NullTime
+	PageLimit     int32
+}
+
+func (q *Queries) ListTasks(ctx context.Context, arg ListTasksParams) ([]Task, error) {
+	rows, err := q.db.QueryContext(ctx, listTasks,
+		arg.AfterID,
+		arg.State,
+		arg.Type,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Type,
+			&i.Value,
+			&i.State,
+			&i.CreationTime,
+			&i.LastUpdateTime,
+			&i.Comment,
+			&i.Attempts,
+			&i.NextRunAt,
+			&i.LastError,
+			&i.Result,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const recordTaskFailure = `-- name: RecordTaskFailure :exec
+UPDATE tasks
+SET state = $1, last_update_time = $2, attempts = $3, next_run_at = $4, last_error = $5, version = version + 1
+WHERE id = $6
+`
+
+type RecordTaskFailureParams struct {
+	State          string
+	LastUpdateTime time.Time
+	Attempts       int32
+	NextRunAt      sql.NullTime
+	LastError      sql.NullString
+	ID             int32
+}
+
+func (q *Queries) RecordTaskFailure(ctx context.Context, arg RecordTaskFailureParams) error {
+	_, err := q.db.ExecContext(ctx, recordTaskFailure,
+		arg.State,
+		arg.LastUpdateTime,
+		arg.Attempts,
+		arg.NextRunAt,
+		arg.LastError,
+		arg.ID,
+	)
+	return err
+}
+
+const recordTaskResult = `-- name: RecordTaskResult :exec
+UPDATE tasks
+SET state = $1, last_update_time = $2, result = $3, version = version + 1
+WHERE id = $4
+`
+
+type RecordTaskResultParams struct {
+	State          string
+	LastUpdateTime time.Time
+	Result         sql.NullString
+	ID             int32
+}
+
+func (q *Queries) RecordTaskResult(ctx context.Context, arg RecordTaskResultParams) error {
+	_, err := q.db.ExecContext(ctx, recordTaskResult,
+		arg.State,
+		arg.LastUpdateTime,
+		arg.Result,
+		arg.ID,
+	)
+	return err
+}
+
+const requeueDeadTask = `-- name: RequeueDeadTask :execrows
+UPDATE tasks
+SET state = 'retrying', last_update_time = $1, attempts = 0, next_run_at = $2, version = version + 1
+WHERE id = $3 AND state = 'dead'
+`
+
+type RequeueDeadTaskParams struct {
+	LastUpdateTime time.Time
+	NextRunAt      sql.NullTime
+	ID             int32
+}
+
+func (q *Queries) RequeueDeadTask(ctx context.Context, arg RequeueDeadTaskParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, requeueDeadTask, arg.LastUpdateTime, arg.NextRunAt, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const updateTaskState = `-- name: UpdateTaskState :exec
 UPDATE tasks
-SET state = $1, last_update_time = $2
+SET state = $1, last_update_time = $2, version = version + 1
 WHERE id = $3
 `
 
@@ -0,0 +1,205 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Values of the grpc_type label.
+const (
+	rpcTypeUnary        = "unary"
+	rpcTypeClientStream = "client_stream"
+	rpcTypeServerStream = "server_stream"
+	rpcTypeBidiStream   = "bidi_stream"
+)
+
+var rpcLabels = []string{"grpc_type", "grpc_service", "grpc_method", "grpc_code"}
+
+// RPCMetrics counts finished RPCs and records their latency by type, service,
+// method and status code. It is a prometheus.Collector, and its interceptors
+// record the RPCs of a server or of a client connection.
+type RPCMetrics struct {
+	handled *prometheus.CounterVec
+	latency *prometheus.HistogramVec
+}
+
+// NewServerRPCMetrics returns the grpc_server_handled_total and
+// grpc_server_handling_seconds metrics for the RPCs a server handles.
+func NewServerRPCMetrics() *RPCMetrics {
+	return newRPCMetrics("server", "handled by the server")
+}
+
+// NewClientRPCMetrics returns the grpc_client_handled_total and
+// grpc_client_handling_seconds metrics for the RPCs a client makes.
+func NewClientRPCMetrics() *RPCMetrics {
+	return newRPCMetrics("client", "completed by the client")
+}
+
+func newRPCMetrics(side, done string) *RPCMetrics {
+	return &RPCMetrics{
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_" + side + "_handled_total",
+			Help: "Total number of RPCs " + done + ", by method and status code",
+		}, rpcLabels),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_" + side + "_handling_seconds",
+			Help:    "Latency of RPCs " + done + ", by method and status code",
+			Buckets: prometheus.DefBuckets,
+		}, rpcLabels),
+	}
+}
+
+// ServerOptions installs the server interceptors.
+func (m *RPCMetrics) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(m.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(m.StreamServerInterceptor()),
+	}
+}
+
+// DialOptions installs the client interceptors.
+func (m *RPCMetrics) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(m.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(m.StreamClientInterceptor()),
+	}
+}
+
+func (m *RPCMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.handled.Describe(ch)
+	m.latency.Describe(ch)
+}
+
+func (m *RPCMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.handled.Collect(ch)
+	m.latency.Collect(ch)
+}
+
+// observe records an RPC to fullMethod that started at start and ended with err.
+func (m *RPCMetrics) observe(rpcType, fullMethod string, start time.Time, err error) {
+	service, method := splitMethod(fullMethod)
+	code := status.Code(err).String()
+
+	m.handled.WithLabelValues(rpcType, service, method, code).Inc()
+	m.latency.WithLabelValues(rpcType, service, method, code).Observe(time.Since(start).Seconds())
+}
+
+// splitMethod splits "/package.Service/Method" into its service and method.
+func splitMethod(fullMethod string) (string, string) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return "unknown", "unknown"
+	}
+	return service, method
+}
+
+func streamType(clientStreams, serverStreams bool) string {
+	switch {
+	case clientStreams && serverStreams:
+		return rpcTypeBidiStream
+	case clientStreams:
+		return rpcTypeClientStream
+	case serverStreams:
+		return rpcTypeServerStream
+	default:
+		return rpcTypeUnary
+	}
+}
+
+func (m *RPCMetrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.observe(rpcTypeUnary, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+func (m *RPCMetrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		m.observe(streamType(info.IsClientStream, info.IsServerStream), info.FullMethod, start, err)
+		return err
+	}
+}
+
+func (m *RPCMetrics) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		m.observe(rpcTypeUnary, method, start, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor records a stream once the client has received its
+// final status: io.EOF from a server stream, the response of a client-only
+// stream, or any error, including one from sending that ends the stream
+// before the client reads its status.
+func (m *RPCMetrics) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		rpcType := streamType(desc.ClientStreams, desc.ServerStreams)
+
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			m.observe(rpcType, method, start, err)
+			return nil, err
+		}
+
+		return &monitoredClientStream{
+			ClientStream:  stream,
+			serverStreams: desc.ServerStreams,
+			done: func(err error) {
+				m.observe(rpcType, method, start, err)
+			},
+		}, nil
+	}
+}
+
+type monitoredClientStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	once          sync.Once
+	done          func(err error)
+}
+
+func (s *monitoredClientStream) RecvMsg(msg any) error {
+	err := s.ClientStream.RecvMsg(msg)
+	switch {
+	case errors.Is(err, io.EOF):
+		s.once.Do(func() { s.done(nil) })
+	case err != nil:
+		s.once.Do(func() { s.done(err) })
+	case !s.serverStreams:
+		s.once.Do(func() { s.done(nil) })
+	}
+	return err
+}
+
+// SendMsg records the stream if sending fails. io.EOF only means the stream
+// ended, with a status that RecvMsg returns.
+func (s *monitoredClientStream) SendMsg(msg any) error {
+	err := s.ClientStream.SendMsg(msg)
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.once.Do(func() { s.done(err) })
+	}
+	return err
+}
+
+func (s *monitoredClientStream) CloseSend() error {
+	err := s.ClientStream.CloseSend()
+	if err != nil {
+		s.once.Do(func() { s.done(err) })
+	}
+	return err
+}
@@ -0,0 +1,163 @@
+package shared
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"golang-assessment/golang-assessment/proto"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type fakeTaskServiceServer struct {
+	proto.UnimplementedTaskServiceServer
+}
+
+func (fakeTaskServiceServer) GetTask(_ context.Context, req *proto.GetTaskRequest) (*proto.Task, error) {
+	if req.Id != 1 {
+		return nil, status.Errorf(codes.NotFound, "task %d not found", req.Id)
+	}
+	return &proto.Task{Id: 1}, nil
+}
+
+func (fakeTaskServiceServer) SendTasks(stream grpc.ClientStreamingServer[proto.TaskRequest, proto.SendTasksResponse]) error {
+	resp := &proto.SendTasksResponse{}
+	for {
+		if _, err := stream.Recv(); err == io.EOF {
+			return stream.SendAndClose(resp)
+		} else if err != nil {
+			return err
+		}
+		resp.Responses = append(resp.Responses, &proto.TaskResponse{})
+	}
+}
+
+func (fakeTaskServiceServer) WatchTasks(_ *proto.WatchTasksRequest, stream grpc.ServerStreamingServer[proto.TaskEvent]) error {
+	return stream.Send(&proto.TaskEvent{Task: &proto.Task{Id: 1}})
+}
+
+func TestRPCMetrics(t *testing.T) {
+	server, client := NewServerRPCMetrics(), NewClientRPCMetrics()
+
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer(server.ServerOptions()...)
+	proto.RegisterTaskServiceServer(grpcServer, fakeTaskServiceServer{})
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	dialOptions := append([]grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, client.DialOptions()...)
+	conn, err := grpc.NewClient("passthrough:///bufnet", dialOptions...)
+	if err != nil {
+		t.Fatalf("Failed to dial server: %v", err)
+	}
+	defer conn.Close()
+	taskClient := proto.NewTaskServiceClient(conn)
+
+	ctx := context.Background()
+	for _, id := range []int32{1, 1, 2} {
+		taskClient.GetTask(ctx, &proto.GetTaskRequest{Id: id})
+	}
+
+	sendStream, err := taskClient.SendTasks(ctx)
+	if err != nil {
+		t.Fatalf("Error in SendTasks: %v", err)
+	}
+	sendStream.Send(&proto.TaskRequest{})
+	if _, err := sendStream.CloseAndRecv(); err != nil {
+		t.Fatalf("Error closing stream: %v", err)
+	}
+
+	watchStream, err := taskClient.WatchTasks(ctx, &proto.WatchTasksRequest{})
+	if err != nil {
+		t.Fatalf("Error in WatchTasks: %v", err)
+	}
+	for {
+		if _, err := watchStream.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Error receiving event: %v", err)
+		}
+	}
+
+	// The server records a stream when its handler returns, which may be
+	// after the client has seen the end of it.
+	grpcServer.GracefulStop()
+
+	tests := []struct {
+		rpcType, method, code string
+		want                  float64
+	}{
+		{rpcTypeUnary, "GetTask", "OK", 2},
+		{rpcTypeUnary, "GetTask", "NotFound", 1},
+		{rpcTypeClientStream, "SendTasks", "OK", 1},
+		{rpcTypeServerStream, "WatchTasks", "OK", 1},
+	}
+	for _, m := range []*RPCMetrics{server, client} {
+		for _, tt := range tests {
+			labels := []string{tt.rpcType, "task.TaskService", tt.method, tt.code}
+			if got := testutil.ToFloat64(m.handled.WithLabelValues(labels...)); got != tt.want {
+				t.Errorf("Expected %v handled %v, got %v", tt.want, labels, got)
+			}
+		}
+	}
+
+	if n := testutil.CollectAndCount(server, "grpc_server_handling_seconds"); n != len(tests) {
+		t.Errorf("Expected %d server latency series, got %d", len(tests), n)
+	}
+	if n := testutil.CollectAndCount(client, "grpc_client_handling_seconds"); n != len(tests) {
+		t.Errorf("Expected %d client latency series, got %d", len(tests), n)
+	}
+}
+
+// failingClientStream fails every send with sendErr and every receive with
+// recvErr.
+type failingClientStream struct {
+	grpc.ClientStream
+	sendErr, recvErr error
+}
+
+func (s failingClientStream) SendMsg(any) error { return s.sendErr }
+func (s failingClientStream) CloseSend() error  { return nil }
+func (s failingClientStream) RecvMsg(any) error { return s.recvErr }
+
+func TestMonitoredClientStreamRecordsFailedSend(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection reset")
+	tests := []struct {
+		name             string
+		sendErr, recvErr error
+		recv             bool
+		want             codes.Code
+	}{
+		{"send fails", unavailable, nil, false, codes.Unavailable},
+		{"stream ended", io.EOF, status.Error(codes.ResourceExhausted, "queue is full"), true, codes.ResourceExhausted},
+	}
+	for _, tt := range tests {
+		var recorded []error
+		stream := &monitoredClientStream{
+			ClientStream: failingClientStream{sendErr: tt.sendErr, recvErr: tt.recvErr},
+			done:         func(err error) { recorded = append(recorded, err) },
+		}
+
+		stream.SendMsg(&proto.TaskRequest{})
+		stream.SendMsg(&proto.TaskRequest{})
+		if tt.recv {
+			stream.RecvMsg(&proto.SendTasksResponse{})
+		}
+
+		if len(recorded) != 1 || status.Code(recorded[0]) != tt.want {
+			t.Errorf("%s: expected the stream recorded once with %v, got %v", tt.name, tt.want, recorded)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// readinessTimeout bounds the time a /readyz request spends in checks.
+const readinessTimeout = 2 * time.Second
+
+// ErrDraining is reported by a service that is shutting down.
+var ErrDraining = errors.New("draining")
+
+// ReadinessCheck reports whether a dependency of the service is usable.
+type ReadinessCheck func(ctx context.Context) error
+
+// GRPCHealthCheck checks that service is SERVING according to the
+// grpc.health.v1 service behind conn.
+func GRPCHealthCheck(conn grpc.ClientConnInterface, service string) ReadinessCheck {
+	client := healthpb.NewHealthClient(conn)
+	return func(ctx context.Context) error {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return err
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("%s is %s", service, resp.Status)
+		}
+		return nil
+	}
+}
+
+// Health serves the /healthz and /readyz endpoints of a service. The service
+// is live as long as it answers, and ready while every readiness check passes
+// and it is not draining.
+type Health struct {
+	mu       sync.Mutex
+	names    []string
+	checks   map[string]ReadinessCheck
+	draining atomic.Bool
+}
+
+func NewHealth() *Health {
+	return &Health{checks: make(map[string]ReadinessCheck)}
+}
+
+// AddCheck adds a readiness check, reported under name.
+func (h *Health) AddCheck(name string, check ReadinessCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.checks[name]; !ok {
+		h.names = append(h.names, name)
+	}
+	h.checks[name] = check
+}
+
+// SetDraining marks the service as shutting down, so that it is no longer
+// ready whatever its checks report.
+func (h *Health) SetDraining() {
+	h.draining.Store(true)
+}
+
+func (h *Health) Draining() bool {
+	return h.draining.Load()
+}
+
+// Check runs every readiness check and returns the error of each failing
+// one by name. It returns nil when the service is ready.
+func (h *Health) Check(ctx context.Context) map[string]error {
+	h.mu.Lock()
+	names := append([]string(nil), h.names...)
+	checks := make(map[string]ReadinessCheck, len(h.checks))
+	for name, check := range h.checks {
+		checks[name] = check
+	}
+	h.mu.Unlock()
+
+	var failed map[string]error
+	fail := func(name string, err error) {
+		if failed == nil {
+			failed = make(map[string]error)
+		}
+		failed[name] = err
+	}
+
+	if h.Draining() {
+		fail("shutdown", ErrDraining)
+	}
+	for _, name := range names {
+		if err := checks[name](ctx); err != nil {
+			fail(name, err)
+		}
+	}
+	return failed
+}
+
+// Ready reports whether every readiness check passes.
+func (h *Health) Ready(ctx context.Context) bool {
+	return h.Check(ctx) == nil
+}
+
+// Register adds the /healthz and /readyz handlers to mux.
+func (h *Health) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", h.serveLiveness)
+	mux.HandleFunc("/readyz", h.serveReadiness)
+}
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func (h *Health) serveLiveness(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// serveReadiness answers 200 when the service is ready, and 503 listing
+// the result of every check otherwise.
+func (h *Health) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	failed := h.Check(ctx)
+	if failed == nil {
+		writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+		return
+	}
+
+	resp := healthResponse{Status: "unavailable", Checks: make(map[string]string)}
+	h.mu.Lock()
+	for _, name := range h.names {
+		resp.Checks[name] = "ok"
+	}
+	h.mu.Unlock()
+	for name, err := range failed {
+		resp.Checks[name] = err.Error()
+	}
+	writeHealth(w, http.StatusServiceUnavailable, resp)
+}
+
+func writeHealth(w http.ResponseWriter, code int, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,104 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func serveHealth(t *testing.T, h *Health, path string) (int, healthResponse) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	h.Register(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var resp healthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid %s response %q: %v", path, rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+func TestHealth(t *testing.T) {
+	h := NewHealth()
+	var dbErr error
+	h.AddCheck("database", func(context.Context) error { return dbErr })
+	h.AddCheck("cache", func(context.Context) error { return nil })
+
+	if code, resp := serveHealth(t, h, "/readyz"); code != http.StatusOK || resp.Status != "ok" {
+		t.Errorf("Expected ready, got %d %+v", code, resp)
+	}
+
+	dbErr = errors.New("connection refused")
+	code, resp := serveHealth(t, h, "/readyz")
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with a failing check, got %d", code)
+	}
+	want := map[string]string{"database": "connection refused", "cache": "ok"}
+	if len(resp.Checks) != len(want) || resp.Checks["database"] != want["database"] || resp.Checks["cache"] != want["cache"] {
+		t.Errorf("Expected checks %v, got %v", want, resp.Checks)
+	}
+
+	// Liveness does not depend on the checks.
+	if code, _ := serveHealth(t, h, "/healthz"); code != http.StatusOK {
+		t.Errorf("Expected live, got %d", code)
+	}
+
+	dbErr = nil
+	h.SetDraining()
+	code, resp = serveHealth(t, h, "/readyz")
+	if code != http.StatusServiceUnavailable || resp.Checks["shutdown"] != ErrDraining.Error() {
+		t.Errorf("Expected not ready while draining, got %d %+v", code, resp)
+	}
+	if code, _ := serveHealth(t, h, "/healthz"); code != http.StatusOK {
+		t.Errorf("Expected live while draining, got %d", code)
+	}
+}
+
+func TestGRPCHealthCheck(t *testing.T) {
+	server := health.NewServer()
+
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, server)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial server: %v", err)
+	}
+	defer conn.Close()
+
+	check := GRPCHealthCheck(conn, "task.TaskService")
+	ctx := context.Background()
+
+	if err := check(ctx); err == nil {
+		t.Errorf("Expected an error for an unknown service")
+	}
+	server.SetServingStatus("task.TaskService", healthpb.HealthCheckResponse_SERVING)
+	if err := check(ctx); err != nil {
+		t.Errorf("Expected a serving service to pass, got %v", err)
+	}
+	server.SetServingStatus("task.TaskService", healthpb.HealthCheckResponse_NOT_SERVING)
+	if err := check(ctx); err == nil {
+		t.Errorf("Expected a service that is not serving to fail")
+	}
+}
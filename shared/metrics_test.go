@@ -0,0 +1,17 @@
+package shared
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewRegistry(t *testing.T) {
+	reg := NewRegistry()
+
+	for _, name := range []string{"go_goroutines", "process_cpu_seconds_total"} {
+		if n, err := testutil.GatherAndCount(reg, name); err != nil || n != 1 {
+			t.Errorf("Expected %s in the registry, got %d series: %v", name, n, err)
+		}
+	}
+}
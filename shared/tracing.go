@@ -0,0 +1,122 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Values of TracingConfig.Exporter.
+const (
+	TraceExporterNone   = "none"
+	TraceExporterOTLP   = "otlp"
+	TraceExporterStdout = "stdout"
+	TraceExporterFile   = "file"
+)
+
+// TracingConfig selects where the services export their OpenTelemetry spans.
+type TracingConfig struct {
+	// Exporter is "otlp", "stdout", "file" or "none". Empty means none.
+	Exporter string `json:"Exporter"`
+	// Endpoint is the host:port of the OTLP gRPC receiver. When empty the
+	// exporter falls back to OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317.
+	Endpoint string `json:"Endpoint"`
+	// Insecure disables TLS towards the OTLP receiver.
+	Insecure bool `json:"Insecure"`
+	// File is where the file exporter writes spans, one JSON object each.
+	File string `json:"File"`
+	// SampleRatio is the fraction of new traces to sample. Zero samples all.
+	SampleRatio float64 `json:"SampleRatio"`
+}
+
+// InitTracing installs the global tracer provider and W3C trace context
+// propagator for service. The returned function flushes pending spans and
+// shuts the provider down.
+func InitTracing(ctx context.Context, service string, config TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	exporter, closer, err := newSpanExporter(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if config.SampleRatio > 0 && config.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(config.SampleRatio)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(service))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}, nil
+}
+
+// newSpanExporter returns the exporter named by the config, and the file it
+// writes to if any. It returns a nil exporter when tracing is disabled.
+func newSpanExporter(ctx context.Context, config TracingConfig) (sdktrace.SpanExporter, io.Closer, error) {
+	switch config.Exporter {
+	case "", TraceExporterNone:
+		return nil, nil, nil
+	case TraceExporterOTLP:
+		var opts []otlptracegrpc.Option
+		if config.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err := otlptracegrpc.New(ctx, opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create OTLP exporter: %v", err)
+		}
+		return exporter, nil, nil
+	case TraceExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create stdout exporter: %v", err)
+		}
+		return exporter, nil, nil
+	case TraceExporterFile:
+		if config.File == "" {
+			return nil, nil, fmt.Errorf("the file trace exporter needs a File")
+		}
+		file, err := os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open trace file: %v", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("failed to create file exporter: %v", err)
+		}
+		return exporter, file, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q", config.Exporter)
+	}
+}
@@ -0,0 +1,46 @@
+package shared
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestInitTracingFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+
+	shutdown, err := InitTracing(context.Background(), "test-service", TracingConfig{Exporter: TraceExporterFile, File: path})
+	if err != nil {
+		t.Fatalf("Error setting up tracing: %v", err)
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "test-span")
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Error shutting down tracing: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading trace file: %v", err)
+	}
+	for _, want := range []string{`"Name":"test-span"`, `"Value":"test-service"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %s in the trace file:\n%s", want, data)
+		}
+	}
+}
+
+func TestInitTracingRejectsUnknownExporter(t *testing.T) {
+	if _, err := InitTracing(context.Background(), "test-service", TracingConfig{Exporter: "carrier-pigeon"}); err == nil {
+		t.Errorf("Expected an error for an unknown exporter")
+	}
+	if _, err := InitTracing(context.Background(), "test-service", TracingConfig{Exporter: TraceExporterFile}); err == nil {
+		t.Errorf("Expected an error for a file exporter without a file")
+	}
+}
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS tasks (
+    id SERIAL PRIMARY KEY,
+    type INTEGER NOT NULL,
+    value INTEGER NOT NULL,
+    state TEXT NOT NULL,
+    creation_time TIMESTAMPTZ NOT NULL,
+    last_update_time TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS tasks_state_next_run_at_idx;
+ALTER TABLE tasks
+    DROP COLUMN last_error,
+    DROP COLUMN next_run_at,
+    DROP COLUMN attempts;
@@ -0,0 +1,5 @@
+ALTER TABLE tasks
+    ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN next_run_at TIMESTAMPTZ,
+    ADD COLUMN last_error TEXT;
+CREATE INDEX IF NOT EXISTS tasks_state_next_run_at_idx ON tasks (state, next_run_at);
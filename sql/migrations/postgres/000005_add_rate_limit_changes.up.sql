@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS rate_limit_changes (
+    id SERIAL PRIMARY KEY,
+    changed_at TIMESTAMPTZ NOT NULL,
+    actor TEXT NOT NULL,
+    previous TEXT NOT NULL,
+    limits TEXT NOT NULL
+);
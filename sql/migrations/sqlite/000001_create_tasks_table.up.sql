@@ -1,5 +1,5 @@
-CREATE TABLE tasks (
-    id SERIAL PRIMARY KEY,
+CREATE TABLE IF NOT EXISTS tasks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
     type INTEGER NOT NULL,
     value INTEGER NOT NULL,
     state TEXT NOT NULL,
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS tasks_state_next_run_at_idx;
+ALTER TABLE tasks DROP COLUMN last_error;
+ALTER TABLE tasks DROP COLUMN next_run_at;
+ALTER TABLE tasks DROP COLUMN attempts;
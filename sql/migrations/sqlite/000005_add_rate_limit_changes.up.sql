@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS rate_limit_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    changed_at TIMESTAMP NOT NULL,
+    actor TEXT NOT NULL,
+    previous TEXT NOT NULL,
+    limits TEXT NOT NULL
+);
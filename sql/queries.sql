@@ -1,10 +1,63 @@
+-- name: CreateTask :one
 INSERT INTO tasks (type, value, state, creation_time, last_update_time)
-VALUES ($1, $2, $3, $4, $5);
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id;
 
+-- name: UpdateTaskState :exec
 UPDATE tasks
-SET state = $1, last_update_time = $2
+SET state = $1, last_update_time = $2, version = version + 1
 WHERE id = $3;
 
-SELECT id, type, value, state, creation_time, last_update_time
+-- name: GetTaskById :one
+SELECT id, type, value, state, creation_time, last_update_time, comment, attempts, next_run_at, last_error, result, version
 FROM tasks
 WHERE id = $1;
+
+-- name: ListTasks :many
+SELECT id, type, value, state, creation_time, last_update_time, comment, attempts, next_run_at, last_error, result, version
+FROM tasks
+WHERE id > sqlc.arg(after_id)
+  AND (sqlc.narg(state) IS NULL OR state = sqlc.narg(state))
+  AND (sqlc.narg(type) IS NULL OR type = sqlc.narg(type))
+  AND (sqlc.narg(created_after) IS NULL OR creation_time >= sqlc.narg(created_after))
+  AND (sqlc.narg(created_before) IS NULL OR creation_time < sqlc.narg(created_before))
+ORDER BY id
+LIMIT sqlc.arg(page_limit);
+
+-- name: CountTasksByState :many
+SELECT state, COUNT(*) AS count
+FROM tasks
+GROUP BY state;
+
+-- name: RecordTaskFailure :exec
+UPDATE tasks
+SET state = $1, last_update_time = $2, attempts = $3, next_run_at = $4, last_error = $5, version = version + 1
+WHERE id = $6;
+
+-- name: ListDueTasks :many
+SELECT id, type, value, state, creation_time, last_update_time, comment, attempts, next_run_at, last_error, result, version
+FROM tasks
+WHERE state = 'retrying' AND next_run_at <= sqlc.arg(next_run_at)
+ORDER BY next_run_at, id
+LIMIT sqlc.arg(page_limit);
+
+-- name: RequeueDeadTask :execrows
+UPDATE tasks
+SET state = 'retrying', last_update_time = $1, attempts = 0, next_run_at = $2, version = version + 1
+WHERE id = $3 AND state = 'dead';
+
+-- name: RecordTaskResult :exec
+UPDATE tasks
+SET state = $1, last_update_time = $2, result = $3, version = version + 1
+WHERE id = $4;
+
+-- name: CreateRateLimitChange :one
+INSERT INTO rate_limit_changes (changed_at, actor, previous, limits)
+VALUES ($1, $2, $3, $4)
+RETURNING id;
+
+-- name: ListRateLimitChanges :many
+SELECT id, changed_at, actor, previous, limits
+FROM rate_limit_changes
+ORDER BY id DESC
+LIMIT $1;